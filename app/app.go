@@ -28,6 +28,8 @@ type AppContext struct {
 	DB       *gorm.DB
 	DevMode  bool
 	postgres *Postgres
+	//AdminToken 管理接口令牌, 空时管理接口不可用
+	AdminToken string
 }
 
 //Init 初始化
@@ -43,8 +45,9 @@ func Close() {
 
 func newAppContext() *AppContext {
 	app := &AppContext{
-		AppName: goboot.Config.MustString("app.name", "pyramid"),
-		DevMode: goboot.Config.MustBool("mode.dev", true),
+		AppName:    goboot.Config.MustString("app.name", "pyramid"),
+		DevMode:    goboot.Config.MustBool("mode.dev", true),
+		AdminToken: goboot.Config.MustString("admin.token", ""),
 		postgres: &Postgres{
 			Host:     goboot.Config.MustString("pg.host", "127.0.0.1"),
 			Port:     goboot.Config.MustInt("pg.port", 5432),
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"./app"
+	"./model"
+)
+
+//runCommand 执行命令行子命令, 返回进程退出码
+//	pyramid [-env dev] reconcile [-fix] [-format json|csv]
+//	pyramid [-env dev] tree -id memberid [-depth n] [-limit n] [-offset n] [-format json|csv|dot]
+func runCommand(args []string) int {
+	switch args[0] {
+	case "reconcile":
+		return reconcileCommand(args[1:])
+	case "tree":
+		return treeCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		return 2
+	}
+}
+
+//reconcileCommand 对账, 结果输出到标准输出; 有差异时退出码为1
+func reconcileCommand(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "write correcting adjustments")
+	format := fs.String("format", "json", "output format: [json|csv]")
+	fs.Parse(args)
+
+	ds, err := model.Reconcile(app.App.DB, *fix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *format == "csv" {
+		err = model.WriteDiscrepanciesCSV(os.Stdout, ds)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(ds)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(ds) > 0 && !*fix {
+		return 1
+	}
+	return 0
+}
+
+//treeCommand 导出会员下级树到标准输出; 之后还有节点时提示下一页偏移
+func treeCommand(args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	id := fs.String("id", "", "member id")
+	depth := fs.Int("depth", 0, "generations to export, 0 for all")
+	limit := fs.Int("limit", 0, "max nodes per page, 0 for default")
+	offset := fs.Int("offset", 0, "nodes to skip")
+	format := fs.String("format", "json", "output format: [json|csv|dot]")
+	fs.Parse(args)
+	if len(*id) == 0 {
+		fmt.Fprintln(os.Stderr, "tree: -id is required")
+		return 2
+	}
+
+	t, err := model.ExportTree(app.App.DB, *id, *depth, *limit, *offset)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	switch *format {
+	case "csv":
+		err = model.WriteTreeCSV(os.Stdout, t)
+	case "dot":
+		err = model.WriteTreeDOT(os.Stdout, t)
+	default:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(t)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if t.Truncated {
+		fmt.Fprintf(os.Stderr, "%d of %d nodes, next page: -offset %d\n", len(t.Nodes), t.Total, t.Offset+len(t.Nodes))
+	}
+	return 0
+}
@@ -44,9 +44,83 @@ func getPara(r *http.Request, key string) string {
 	return ""
 }
 
+//AdminOnly 管理接口校验, header X-Admin-Token 或参数token 须与配置admin.token一致
+func AdminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm() //解析参数，默认是不会解析的
+		token := r.Header.Get("X-Admin-Token")
+		if len(token) == 0 {
+			token = getPara(r, "token")
+		}
+		if len(app.App.AdminToken) == 0 || token != app.App.AdminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, jsonString(&msgResp{model.ResUnauthorized, "无管理权限"}))
+			return
+		}
+		h(w, r)
+	}
+}
+
+//getUnit 金额单位参数unit, fen|yuan, 缺省fen
+func getUnit(r *http.Request) (string, bool) {
+	unit := getPara(r, "unit")
+	return unit, model.ValidUnit(unit)
+}
+
+//getMoneyPara 按单位解析金额参数, 返回以分为单位的字符串; 参数为空时返回空串
+func getMoneyPara(r *http.Request, key string, unit string) (string, error) {
+	str := getPara(r, key)
+	if len(str) == 0 {
+		return "", nil
+	}
+	m, err := model.ParseMoney(str, unit)
+	if err != nil {
+		return "", err
+	}
+	return m.Amount.String(), nil
+}
+
+//itemPara 消费明细参数
+type itemPara struct {
+	SKU      string `json:"sku"`
+	Category string `json:"category"`
+	Amount   string `json:"amount"`
+}
+
+//getItemsPara 解析消费明细参数items, json数组, 金额单位同unit
+//	例: [{"sku":"A001","category":"food","amount":"1200"}]
+func getItemsPara(r *http.Request, unit string) ([]model.ConsumeItem, error) {
+	str := getPara(r, "items")
+	if len(str) == 0 {
+		return nil, nil
+	}
+	var ps []itemPara
+	if err := json.Unmarshal([]byte(str), &ps); err != nil {
+		return nil, err
+	}
+	items := make([]model.ConsumeItem, len(ps))
+	for i, p := range ps {
+		m, err := model.ParseMoney(p.Amount, unit)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = model.ConsumeItem{SKU: p.SKU, Category: p.Category, Amount: m.Amount}
+	}
+	return items, nil
+}
+
+//getIdempotencyKey 获取幂等键, header Idempotency-Key 优先, 其次参数idemkey
+func getIdempotencyKey(r *http.Request) string {
+	if key := r.Header.Get("Idempotency-Key"); len(key) > 0 {
+		return key
+	}
+	return getPara(r, "idemkey")
+}
+
 //Bind 绑定推荐用户
 //	  id     :被绑定会员id
 //	  refid  :推荐会员id
+//	  解绑(见Unbind)后冷静期内不能绑定; 会员已有下级时一并重建下级关系
 //	  return :
 //	    code = "200" 成功
 //	    code = "412" 参数不足
@@ -110,6 +184,11 @@ func (c *Controller) history(w http.ResponseWriter, r *http.Request, greaterOrLe
 		id = members[0].ID
 		name = members[0].Name.String
 	}
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
 	str := getPara(r, "pagesize")
 	size, _ := strconv.Atoi(str)
 	str = getPara(r, "offset")
@@ -136,6 +215,9 @@ func (c *Controller) history(w http.ResponseWriter, r *http.Request, greaterOrLe
 			}
 		}
 	}
+	for i := range history {
+		history[i].Amount = history[i].Amount.To(unit)
+	}
 	resp := historyResp{model.ResOK, name, history}
 	fmt.Fprintf(w, jsonString(resp))
 }
@@ -144,6 +226,7 @@ func (c *Controller) history(w http.ResponseWriter, r *http.Request, greaterOrLe
 //  id      : memberid
 //  pagesize: optional
 //  offset  : optional
+//  unit    : 金额单位, fen|yuan, 缺省fen
 //  start   : 2016-1-1
 //  end     : 2016-1-2
 //  return :
@@ -158,6 +241,7 @@ func (c *Controller) GainHistory(w http.ResponseWriter, r *http.Request) {
 //  id      : memberid
 //  pagesize: optional
 //  offset  : optional
+//  unit    : 金额单位, fen|yuan, 缺省fen
 //  start   : 2016-1-1
 //  end     : 2016-1-2
 //  return :
@@ -169,9 +253,11 @@ func (c *Controller) ConsumeHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 type checkAccountResp struct {
-	RespCode string `json:"respCode"`
-	RespMsg  string `json:"respMsg"`
-	Points   string `json:"points"`
+	RespCode string                `json:"respCode"`
+	RespMsg  string                `json:"respMsg"`
+	Points   model.Money           `json:"points"`
+	Pending  model.Money           `json:"pending"`
+	Wallets  []model.WalletBalance `json:"wallets"`
 }
 
 type membersResp struct {
@@ -194,8 +280,9 @@ type referencesResp struct {
 //  cardno  : 是否使用余额,缺省否
 //  name    : 姓名,姓名为关键字时,结果可能多个
 //  至少1个不为空
+//  unit    : 金额单位, fen|yuan, 缺省fen
 //  return :
-//    code = "200" 成功
+//    code = "200" 成功, points 有效余额, pending 待到账(到账期限内)返利, wallets 各钱包余额及待到账金额
 //    code = "300" 返回多位用户, 需要从多人中选择
 //    code = "500" 内部错误
 func (c *Controller) CheckAccount(w http.ResponseWriter, r *http.Request) {
@@ -235,30 +322,55 @@ func (c *Controller) CheckAccount(w http.ResponseWriter, r *http.Request) {
 	resp := checkAccountResp{}
 	resp.RespCode = model.ResOK
 	resp.RespMsg = ok
-	resp.Points = d.String()
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	resp.Points = model.NewMoney(d).To(unit)
+	if resp.Wallets, err = model.GetWalletBalances(app.App.DB, id); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+	resp.Pending = model.PendingTotal(resp.Wallets).To(unit)
+	for i := range resp.Wallets {
+		resp.Wallets[i].Amount = resp.Wallets[i].Amount.To(unit)
+		resp.Wallets[i].Pending = resp.Wallets[i].Pending.To(unit)
+	}
 	//fmt.Println("ck account:", resp)
 	fmt.Fprintf(w, jsonString(resp))
 }
 
 type consumeResp struct {
-	RespCode       string `json:"respCode"`
-	RespMsg        string `json:"respMsg"`
-	MemberID       string `json:"id"`
-	PointUsed      string `json:"pointused"`
-	PayAmount      string `json:"payamount"`
-	SelfGainPoints string `json:"selfgainpoints"`
-	GainPoints     string `json:"gainpoints"`
+	RespCode       string               `json:"respCode"`
+	RespMsg        string               `json:"respMsg"`
+	MemberID       string               `json:"id"`
+	PointUsed      model.Money          `json:"pointused"`
+	PayAmount      model.Money          `json:"payamount"`
+	SelfGainPoints model.Money          `json:"selfgainpoints"`
+	GainPoints     model.Money          `json:"gainpoints"`
+	RequestID      string               `json:"requestid,omitempty"`
+	Fee            *model.Money         `json:"fee,omitempty"`
+	CapsHit        []model.RebateCapHit `json:"capshit,omitempty"`
+	Campaigns      []int                `json:"campaigns,omitempty"`
 }
 
-//Cashout 提现
+//Cashout 提现申请, 扣减积分并创建待审批的提现申请
 //  id      : memberid
-//  amount  : 提现金额 单位分, 例:120 = 1块2毛
+//  amount  : 提现金额 单位同unit, 例:120(分) = 1.2(元)
+//  unit    : 金额单位, fen|yuan, 缺省fen; 返回金额同此单位
 //  orderno : 订单号
+//  idemkey : 幂等键, 可选, 也可用header Idempotency-Key; 缺省使用订单号
 //  return  :
-//    code = "200" 成功
+//    code = "200" 成功, requestid 为提现申请id, fee 为另行扣减的手续费; 幂等键重复且请求一致时, 返回原结果
 //    code = "201" 订单号重复, 订单号空时不做检查. 订单号与用户id联合检查, 相同用户id下,
 //           订单号重复才失败;用户不同, 订单号相同,不算重复
-//    code = "412" 余额不足
+//    code = "409" 幂等键重复, 请求内容与原请求不一致
+//    code = "412" 余额不足(含手续费)
+//    code = "4122" 低于最低提现金额
+//    code = "4123" 超过单笔提现上限
+//    code = "4124" 超过每日提现上限
+//    code = "4125" 超过每月提现上限
 //    code = "500" 内部错误
 func (c *Controller) Cashout(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm() //解析参数，默认是不会解析的
@@ -274,10 +386,19 @@ func (c *Controller) Cashout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	amount := getPara(r, "amount")
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
 	order := getPara(r, "orderno")
 	//fmt.Println("consume:", id, amount, usePoint)
-	pointUsed, code, msg := model.Cashout(app.App.DB, m, amount, order)
+	result, code, msg := model.Cashout(app.App.DB, m, amount, order, getIdempotencyKey(r))
 	if code != model.ResOK {
 		fmt.Fprintf(w, errMsg.messageString(code, msg))
 	} else {
@@ -285,20 +406,30 @@ func (c *Controller) Cashout(w http.ResponseWriter, r *http.Request) {
 		resp.RespCode = model.ResOK
 		resp.RespMsg = ok
 		resp.MemberID = m.ID
-		resp.PointUsed = pointUsed
+		resp.PointUsed = result.PointUsed.To(unit)
+		resp.RequestID = result.RequestID
+		fee := result.Fee.To(unit)
+		resp.Fee = &fee
 		fmt.Fprintf(w, jsonString(resp))
 	}
 }
 
 //Consume 消耗积分
 //  id      : memberid
-//  amount  : 消费金额 单位分, 例:120 = 1块2毛
+//  amount  : 消费金额 单位同unit, 例:120(分) = 1.2(元)
+//  unit    : 金额单位, fen|yuan, 缺省fen; 返回金额同此单位
 //  usepoint: 是否使用余额,缺省否
 //  orderno : 订单号
+//  idemkey : 幂等键, 可选, 也可用header Idempotency-Key; 缺省使用订单号
+//  merchant: 商户(门店), 可选, 用于匹配返利规则
+//  items   : 消费明细, 可选, json数组, 例:[{"sku":"A001","category":"food","amount":"1200"}],
+//            金额单位同unit, 合计须等于amount; 按商户及类别匹配返利规则, 排除的类别不返利
 //  return  :
-//    code = "200" 成功
+//    code = "200" 成功, capshit 为触发的返利上限(cap: level|daily|monthly|order, cut 削减金额); 幂等键重复且请求一致时, 返回原结果
+//           campaigns 为产生活动返利的促销活动id
 //    code = "201" 订单号重复, 订单号空时不做检查. 订单号与用户id联合检查, 相同用户id下,
 //           订单号重复才失败;用户不同, 订单号相同,不算重复
+//    code = "409" 幂等键重复, 请求内容与原请求不一致
 //    code = "500" 内部错误
 func (c *Controller) Consume(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm() //解析参数，默认是不会解析的
@@ -315,32 +446,452 @@ func (c *Controller) Consume(w http.ResponseWriter, r *http.Request) {
 	}
 
 	usePoint := getPara(r, "usepoint")
-	amount := getPara(r, "amount")
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
 	order := getPara(r, "orderno")
 	//fmt.Println("consume:", id, amount, usePoint)
-	result, err := model.Consume(app.App.DB, m, amount, usePoint, order)
+	items, err := getItemsPara(r, unit)
+	if err == nil {
+		if d, e := model.ParseMoney(amount, model.UnitFen); e == nil {
+			err = model.CheckItems(items, d.Amount)
+		}
+	}
 	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效消费明细: "+err.Error()))
+		return
+	}
+	result, err := model.Consume(app.App.DB, m, amount, usePoint, order, getIdempotencyKey(r), getPara(r, "merchant"), items)
+	if err == model.ErrIdempotencyConflict {
+		fmt.Fprintf(w, errMsg.messageString(model.ResConflict, err.Error()))
+	} else if err != nil {
 		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
 	} else {
 		resp := consumeResp{}
 		resp.RespCode = model.ResOK
 		resp.RespMsg = ok
 		resp.MemberID = m.ID
-		resp.GainPoints = result.GainPoints
-		resp.PayAmount = result.PayAmount
-		resp.PointUsed = result.PointUsed
-		resp.SelfGainPoints = result.SelfGainPoints
+		resp.GainPoints = result.GainPoints.To(unit)
+		resp.PayAmount = result.PayAmount.To(unit)
+		resp.PointUsed = result.PointUsed.To(unit)
+		resp.SelfGainPoints = result.SelfGainPoints.To(unit)
+		resp.CapsHit = result.CapsHit
+		for i := range resp.CapsHit {
+			resp.CapsHit[i].Cut = resp.CapsHit[i].Cut.To(unit)
+		}
+		resp.Campaigns = result.Campaigns
 		fmt.Fprintf(w, jsonString(resp))
 	}
 }
 
-type userResp struct {
+type refundResp struct {
+	RespCode       string      `json:"respCode"`
+	RespMsg        string      `json:"respMsg"`
+	MemberID       string      `json:"id"`
+	OrderNo        string      `json:"orderno"`
+	PointRestored  model.Money `json:"pointrestored"`
+	ClawbackPoints model.Money `json:"clawbackpoints"`
+	Refunded       model.Money `json:"refunded"`
+	Cancelled      model.Money `json:"pendingcancelled"`
+}
+
+//Refund 订单退款冲正, 扣回各级返利, 退还抵用积分
+//  id      : memberid
+//  orderno : 原订单号
+//  amount  : 退款金额 单位同unit, 可选, 缺省退还订单剩余全部金额; 部分退款按比例扣回返利,退还抵用积分
+//  unit    : 金额单位, fen|yuan, 缺省fen; 返回金额同此单位
+//  return  :
+//    code = "200" 成功, pendingcancelled 为扣回返利中直接取消的待到账(到账期限内)返利
+//    code = "201" 订单已全额退款
+//    code = "404" 订单不存在
+//    code = "412" 参数不足, 或退款金额超过订单可退金额
+//    code = "500" 内部错误
+func (c *Controller) Refund(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	order := getPara(r, "orderno")
+	if len(id) == 0 || len(order) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	m := model.NewMember()
+	errMsg := &msgResp{}
+	if err := m.FindByID(app.App.DB, id); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
+	result, code, msg := model.Refund(app.App.DB, m, order, amount)
+	if code != model.ResOK {
+		fmt.Fprintf(w, errMsg.messageString(code, msg))
+	} else {
+		resp := refundResp{}
+		resp.RespCode = model.ResOK
+		resp.RespMsg = ok
+		resp.MemberID = m.ID
+		resp.OrderNo = order
+		resp.PointRestored = result.PointRestored.To(unit)
+		resp.ClawbackPoints = result.ClawbackPoints.To(unit)
+		resp.Refunded = result.Refunded.To(unit)
+		resp.Cancelled = result.PendingCancelled.To(unit)
+		fmt.Fprintf(w, jsonString(resp))
+	}
+}
+
+type adjustResp struct {
+	RespCode      string `json:"respCode"`
+	RespMsg       string `json:"respMsg"`
+	MemberID      string `json:"id"`
+	TransactionID string `json:"transactionid"`
+}
+
+//Adjust 人工调整积分, 管理接口
+//  id        : memberid
+//  direction : credit 调增, debit 调减
+//  amount    : 调整金额 单位同unit, 例:120(分) = 1.2(元)
+//  unit      : 金额单位, fen|yuan, 缺省fen
+//  reason    : 调整原因代码, compensation|correction|goodwill|fraud|other
+//  operator  : 操作员id
+//  remark    : 备注, 可选
+//  expiredate: 调增积分过期日, 可选, 例:2017-12-31
+//  wallet    : 钱包类型, rebate|stored|promo, 可选; 调增缺省rebate, 调减缺省按扣减顺序扣减全部钱包
+//  return  :
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "412" 参数不足, 或余额不足
+//    code = "500" 内部错误
+func (c *Controller) Adjust(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	if len(id) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	m := model.NewMember()
+	errMsg := &msgResp{}
+	if err := m.FindByID(app.App.DB, id); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+	var expire *time.Time
+	if str := getPara(r, "expiredate"); len(str) > 0 {
+		if expire = stringToTime(str); expire == nil {
+			fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效过期日"))
+			return
+		}
+	}
+
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
+	tID, code, msg := model.Adjust(app.App.DB, m, getPara(r, "direction"), amount,
+		getPara(r, "reason"), getPara(r, "operator"), getPara(r, "remark"), expire, getPara(r, "wallet"))
+	if code != model.ResOK {
+		fmt.Fprintf(w, errMsg.messageString(code, msg))
+		return
+	}
+	fmt.Fprintf(w, jsonString(adjustResp{model.ResOK, ok, m.ID, tID}))
+}
+
+type cashoutListResp struct {
+	RespCode string                 `json:"respCode"`
+	RespMsg  string                 `json:"respMsg"`
+	Requests []model.CashoutRequest `json:"requests"`
+}
+
+//CashoutList 提现申请列表, 管理接口
+//  status  : requested|approved|rejected|paid|failed, 缺省全部
+//  pagesize: 每页记录数, 缺省50
+//  offset  : 偏移
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) CashoutList(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	size, _ := strconv.Atoi(getPara(r, "pagesize"))
+	offset, _ := strconv.Atoi(getPara(r, "offset"))
+	cs, err := model.ListCashoutRequests(app.App.DB, getPara(r, "status"), size, offset)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(cashoutListResp{model.ResOK, ok, cs}))
+}
+
+//CashoutAction 提现申请审批/打款, 管理接口
+//  requestid : 提现申请id
+//  action    : approve|reject (requested状态), pay|fail (approved状态)
+//  operator  : 操作员id
+//  remark    : 备注, 可选
+//  reject/fail 时退还冻结的积分
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "404" 提现申请不存在
+//    code = "412" 参数不足, 或当前状态不允许该操作
+//    code = "500" 内部错误
+func (c *Controller) CashoutAction(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	reqID := getPara(r, "requestid")
+	if len(reqID) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	code, msg := model.CashoutAction(app.App.DB, reqID, getPara(r, "action"), getPara(r, "operator"), getPara(r, "remark"))
+	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+}
+
+type cashoutHistoryResp struct {
 	RespCode  string             `json:"respCode"`
 	RespMsg   string             `json:"respMsg"`
-	Member    model.MemberOutput `json:"member"`
-	Reference model.MemberOutput `json:"reference"`
-	Amount    string             `json:"amount"`
-	Total     string             `json:"total"`
+	RequestID string             `json:"requestid"`
+	History   []model.CashoutLog `json:"history"`
+}
+
+//CashoutHistory 提现申请状态变更记录, 管理接口
+//  requestid : 提现申请id
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) CashoutHistory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	reqID := getPara(r, "requestid")
+	if len(reqID) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	ls, err := model.CashoutHistory(app.App.DB, reqID)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(cashoutHistoryResp{model.ResOK, ok, reqID, ls}))
+}
+
+type cashoutPoliciesResp struct {
+	RespCode string                      `json:"respCode"`
+	RespMsg  string                      `json:"respMsg"`
+	Policies []model.CashoutPolicyOutput `json:"policies"`
+}
+
+//CashoutPolicies 提现规则列表, 管理接口; level为空的为全局规则
+func (c *Controller) CashoutPolicies(w http.ResponseWriter, r *http.Request) {
+	ps, err := model.GetCashoutPolicies(app.App.DB)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(cashoutPoliciesResp{model.ResOK, ok, ps}))
+}
+
+//SetCashoutPolicy 设置提现规则, 管理接口
+//  level   : 会员等级, 空时设置全局规则
+//  min     : 单笔最低金额, 单位分, 0或空不限, 下同
+//  max     : 单笔最高金额
+//  daily   : 每日累计上限
+//  monthly : 每月累计上限
+//  feeflat : 每笔固定手续费
+//  feerate : 按金额收取手续费比例, 例:0.01 = 1%
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "412" 参数错误
+//    code = "500" 内部错误
+func (c *Controller) SetCashoutPolicy(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	code, msg := model.SetCashoutPolicy(app.App.DB, getPara(r, "level"), getPara(r, "min"), getPara(r, "max"),
+		getPara(r, "daily"), getPara(r, "monthly"), getPara(r, "feeflat"), getPara(r, "feerate"))
+	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+}
+
+type rechargeResp struct {
+	RespCode   string               `json:"respCode"`
+	RespMsg    string               `json:"respMsg"`
+	MemberID   string               `json:"id"`
+	RechargeID string               `json:"rechargeid"`
+	Amount     model.Money          `json:"amount"`
+	Bonus      model.Money          `json:"bonus"`
+	GainPoints model.Money          `json:"gainpoints"`
+	CapsHit    []model.RebateCapHit `json:"capshit,omitempty"`
+}
+
+//Recharge 渠道充值, 计入储值钱包, 按赠送档位另行赠送促销积分
+//  id          : memberid
+//  amount      : 充值金额 单位同unit, 例:120(分) = 1.2(元)
+//  unit        : 金额单位, fen|yuan, 缺省fen; 返回金额同此单位
+//  channel     : 支付渠道
+//  externalref : 渠道流水号, 渠道+流水号唯一, 重复请求返回原结果
+//  return  :
+//    code = "200" 成功, bonus 赠送金额, gainpoints 上级返利合计(RechargeRebate开启时)
+//    code = "201" 订单号(渠道:流水号)重复
+//    code = "409" 渠道流水号重复, 会员或金额与原请求不一致
+//    code = "412" 参数不足
+//    code = "500" 内部错误
+func (c *Controller) Recharge(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	if len(id) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	m := model.NewMember()
+	errMsg := &msgResp{}
+	if err := m.FindByID(app.App.DB, id); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
+	result, code, msg := model.RechargeStored(app.App.DB, m, amount, getPara(r, "channel"), getPara(r, "externalref"))
+	if code != model.ResOK {
+		fmt.Fprintf(w, errMsg.messageString(code, msg))
+		return
+	}
+	resp := rechargeResp{model.ResOK, ok, m.ID, result.RechargeID, result.Amount.To(unit), result.Bonus.To(unit),
+		result.GainPoints.To(unit), result.CapsHit}
+	for i := range resp.CapsHit {
+		resp.CapsHit[i].Cut = resp.CapsHit[i].Cut.To(unit)
+	}
+	fmt.Fprintf(w, jsonString(resp))
+}
+
+type rechargeTiersResp struct {
+	RespCode string               `json:"respCode"`
+	RespMsg  string               `json:"respMsg"`
+	Tiers    []model.RechargeTier `json:"tiers"`
+}
+
+//RechargeTiers 充值赠送档位列表, 管理接口
+func (c *Controller) RechargeTiers(w http.ResponseWriter, r *http.Request) {
+	ts, err := model.GetRechargeTiers(app.App.DB)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(rechargeTiersResp{model.ResOK, ok, ts}))
+}
+
+//SetRechargeTier 设置充值赠送档位, 管理接口; 充值金额不低于min的最高档位生效
+//  min    : 充值金额, 单位分, 例:50000 充500元
+//  bonus  : 赠送金额, 单位分, 例:5000 送50元, 计入促销钱包
+//  delete : bool 删除该档位, 缺省否
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "404" 删除的档位不存在
+//    code = "412" 参数错误
+//    code = "500" 内部错误
+func (c *Controller) SetRechargeTier(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	remove, _ := strconv.ParseBool(getPara(r, "delete"))
+	code, msg := model.SetRechargeTier(app.App.DB, getPara(r, "min"), getPara(r, "bonus"), remove)
+	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+}
+
+type transferResp struct {
+	RespCode string      `json:"respCode"`
+	RespMsg  string      `json:"respMsg"`
+	MemberID string      `json:"id"`
+	ToID     string      `json:"toid"`
+	Amount   model.Money `json:"amount"`
+}
+
+//Transfer 会员间转赠积分
+//  id        : 转出memberid
+//  toid      : 转入memberid
+//  amount    : 转赠金额 单位同unit, 例:120(分) = 1.2(元)
+//  unit      : 金额单位, fen|yuan, 缺省fen
+//  orderno   : 订单号, 可选
+//  keepexpiry: bool 转入积分是否沿用转出积分中最早的过期日, 缺省否
+//  idemkey   : 幂等键, 可选, 也可用header Idempotency-Key; 缺省使用订单号
+//  return  :
+//    code = "200" 成功
+//    code = "201" 订单号重复
+//    code = "409" 幂等键重复, 请求内容与原请求不一致
+//    code = "412" 参数不足, 余额不足, 超过每日上限或转赠未开启
+//    code = "500" 内部错误
+func (c *Controller) Transfer(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	toID := getPara(r, "toid")
+	if len(id) == 0 || len(toID) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	from := model.NewMember()
+	to := model.NewMember()
+	errMsg := &msgResp{}
+	if err := from.FindByID(app.App.DB, id); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+	if err := to.FindByID(app.App.DB, toID); err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResFail, err.Error()))
+		return
+	}
+
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, "无效金额单位"))
+		return
+	}
+	amount, err := getMoneyPara(r, "amount", unit)
+	if err != nil {
+		fmt.Fprintf(w, errMsg.messageString(model.ResInvalid, err.Error()))
+		return
+	}
+	keep, _ := strconv.ParseBool(getPara(r, "keepexpiry"))
+	code, msg := model.Transfer(app.App.DB, from, to, amount, getPara(r, "orderno"), keep, getIdempotencyKey(r))
+	if code != model.ResOK {
+		fmt.Fprintf(w, errMsg.messageString(code, msg))
+		return
+	}
+	m, _ := model.ParseMoney(amount, model.UnitFen)
+	fmt.Fprintf(w, jsonString(transferResp{model.ResOK, ok, from.ID, to.ID, m.To(unit)}))
+}
+
+type userResp struct {
+	RespCode  string                `json:"respCode"`
+	RespMsg   string                `json:"respMsg"`
+	Member    model.MemberOutput    `json:"member"`
+	Reference model.MemberOutput    `json:"reference"`
+	Amount    string                `json:"amount"`
+	Total     string                `json:"total"`
+	Wallets   []model.WalletBalance `json:"wallets,omitempty"`
 }
 
 //jsonString output jason object
@@ -365,6 +916,7 @@ func (r *userResp) CopyMemberInfo(m *model.Member, withAccount bool) {
 		t, _ := model.GetAmountByMember(app.App.DB, m.ID, false)
 		r.Amount = a.String()
 		r.Total = t.Add(a).String()
+		r.Wallets, _ = model.GetWalletBalances(app.App.DB, m.ID)
 	}
 }
 
@@ -499,6 +1051,35 @@ func (c *Controller) Reference(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonString(fillMemberMessageByCode(code, msg)))
 }
 
+//Ancestors 查找会员全部上级, 按代数, 不受返利层数限制
+//  id    : memberid
+//  phone : 电话
+//  cardno: 卡号
+//  name  : 姓名,姓名为关键字时,结果可能多个
+//	至少1个不为空
+//  return:
+//    code = "200" 成功, members 为各代上级, generations 1 为直接推荐人
+//    code = "300" 需要从多人中选择
+//    code = "500" 内部错误
+func (c *Controller) Ancestors(w http.ResponseWriter, r *http.Request) {
+	members, code, msg := searchMember(r)
+	if code == model.ResMore {
+		fmt.Fprintf(w, jsonString(membersResp{model.ResMore, "请选择用户", model.MapMembers2Output(members)}))
+		return
+	}
+	if code == model.ResFound {
+		m := members[0]
+		refs, err := model.FindAncestorsByID(app.App.DB, m.ID)
+		if err == nil {
+			fmt.Fprintf(w, jsonString(referencesResp{model.ResOK, "OK", m.ID, m.Name.String, model.MapReference2Output(refs)}))
+			return
+		}
+		code = model.ResFail
+		msg = err.Error()
+	}
+	fmt.Fprintf(w, jsonString(fillMemberMessageByCode(code, msg)))
+}
+
 //Members 查找用户列表
 //  id    : memberid
 //  phone : 电话
@@ -525,7 +1106,7 @@ func (c *Controller) Members(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonString(fillMemberMessageByCode(code, msg)))
 }
 
-//GetRatio 获取当前分成比例
+//GetRatio 获取当前分成比例, 含会员等级分成比例tiers(等级 -> 各代比例)
 func (c *Controller) GetRatio(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, model.GetRatioJSON())
 }
@@ -534,10 +1115,15 @@ func (c *Controller) GetRatio(w http.ResponseWriter, r *http.Request) {
 //	ratio :
 //	syncall : bool是否更新已有记录
 //	updateall : bool更新是否检查与现有ratio相同, true 所有更新, false只更新与当前ratio相同的
+//	tier : 会员等级, 非空时设置该等级各代分成比例(ratio为空时删除该等级配置), 忽略syncall, updateall
 func (c *Controller) SetRatio(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	ratios := r.Form["ratio"]
-	fmt.Println(ratios)
+	if tier := getPara(r, "tier"); len(tier) > 0 {
+		code, msg := model.UpdateTierRatios(app.App.DB, tier, ratios)
+		fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+		return
+	}
 	sync := getPara(r, "syncall")
 	updAll := getPara(r, "updateall")
 
@@ -545,6 +1131,367 @@ func (c *Controller) SetRatio(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
 }
 
+type rebateRulesResp struct {
+	RespCode string                   `json:"respCode"`
+	RespMsg  string                   `json:"respMsg"`
+	Rules    []model.RebateRuleOutput `json:"rules"`
+}
+
+//RebateRules 返利规则列表, 管理接口
+func (c *Controller) RebateRules(w http.ResponseWriter, r *http.Request) {
+	rs, err := model.GetRebateRules(app.App.DB)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(rebateRulesResp{model.ResOK, ok, rs}))
+}
+
+//SetRebateRule 设置返利规则, 管理接口
+//  merchant : 商户(门店), 空为不限
+//  category : 商品类别, 空为不限; 与merchant不能均为空
+//  ratio    : 各级分成比例, 百分数, 可多个, 同setratio
+//  excluded : bool 匹配的商品不返利, 缺省否
+//  delete   : bool 删除该规则, 缺省否
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "404" 删除的规则不存在
+//    code = "412" 参数错误
+//    code = "500" 内部错误
+func (c *Controller) SetRebateRule(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	excluded, _ := strconv.ParseBool(getPara(r, "excluded"))
+	remove, _ := strconv.ParseBool(getPara(r, "delete"))
+	code, msg := model.SetRebateRule(app.App.DB, getPara(r, "merchant"), getPara(r, "category"), r.Form["ratio"], excluded, remove)
+	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+}
+
+type campaignsResp struct {
+	RespCode  string                 `json:"respCode"`
+	RespMsg   string                 `json:"respMsg"`
+	Campaigns []model.CampaignOutput `json:"campaigns"`
+}
+
+//Campaigns 促销活动列表, 管理接口
+//  active : bool 仅当前有效的活动, 缺省否
+func (c *Controller) Campaigns(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	active, _ := strconv.ParseBool(getPara(r, "active"))
+	cs, err := model.GetCampaigns(app.App.DB, active)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(campaignsResp{model.ResOK, ok, cs}))
+}
+
+type setCampaignResp struct {
+	RespCode string `json:"respCode"`
+	RespMsg  string `json:"respMsg"`
+	ID       int    `json:"id"`
+}
+
+//SetCampaign 新增或修改促销活动, 管理接口; 活动返利在常规返利之外产生, 不影响setratio的分成比例
+//  campaignid : 活动id, 空时新增
+//  name       : 活动名称
+//  start, end : 起止时间, 格式 2006-01-02 15:04, 不含结束时间
+//  level      : 消费会员等级, 空为不限
+//  branch     : 商户(门店), 同消费接口merchant, 仅该商户的消费, 空为不限
+//  newmember  : bool 仅会员首单, 缺省否
+//  multiplier : 返利倍数, 例:2 双倍返利
+//  ratio      : 替换的各级分成比例, 百分数, 可多个, 同setratio; 与multiplier二选一
+//  delete     : bool 删除该活动, 缺省否; 已产生返利的活动不能删除, 可修改结束时间提前结束
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "404" 活动不存在
+//    code = "412" 参数错误
+//    code = "500" 内部错误
+func (c *Controller) SetCampaign(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id, _ := strconv.Atoi(getPara(r, "campaignid"))
+	newMember, _ := strconv.ParseBool(getPara(r, "newmember"))
+	remove, _ := strconv.ParseBool(getPara(r, "delete"))
+	id, code, msg := model.SetCampaign(app.App.DB, id, getPara(r, "name"), getPara(r, "start"), getPara(r, "end"),
+		getPara(r, "level"), getPara(r, "branch"), newMember, getPara(r, "multiplier"), r.Form["ratio"], remove)
+	if code != model.ResOK {
+		fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(setCampaignResp{code, msg, id}))
+}
+
+type tierRulesResp struct {
+	RespCode string           `json:"respCode"`
+	RespMsg  string           `json:"respMsg"`
+	Rules    []model.TierRule `json:"rules"`
+}
+
+//TierRules 会员等级评定规则列表, 管理接口
+func (c *Controller) TierRules(w http.ResponseWriter, r *http.Request) {
+	rs, err := model.GetTierRules(app.App.DB)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(tierRulesResp{model.ResOK, ok, rs}))
+}
+
+//SetTierRule 设置会员等级评定规则, 管理接口; 由定时任务按规则评定会员等级
+//  level         : 会员等级, 整数, 满足多个规则时取最高等级
+//  minspend      : 统计期内累计消费, 单位分, 0或空不限, 下同
+//  minreferrals  : 直接推荐人数
+//  minteamvolume : 统计期内下级累计消费
+//  delete        : bool 删除该规则, 缺省否
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "404" 删除的规则不存在
+//    code = "412" 参数错误
+//    code = "500" 内部错误
+func (c *Controller) SetTierRule(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	remove, _ := strconv.ParseBool(getPara(r, "delete"))
+	code, msg := model.SetTierRule(app.App.DB, getPara(r, "level"), getPara(r, "minspend"),
+		getPara(r, "minreferrals"), getPara(r, "minteamvolume"), remove)
+	fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+}
+
+type tierChangesResp struct {
+	RespCode string                   `json:"respCode"`
+	RespMsg  string                   `json:"respMsg"`
+	Changes  []model.TierChangeOutput `json:"changes"`
+}
+
+//TierChanges 会员等级变更记录, 管理接口
+//  id      : memberid, 空为全部会员
+//  pagesize: 每页记录数, 缺省500
+//  offset  : 偏移
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) TierChanges(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	size, _ := strconv.Atoi(getPara(r, "pagesize"))
+	offset, _ := strconv.Atoi(getPara(r, "offset"))
+	cs, err := model.GetTierChanges(app.App.DB, getPara(r, "id"), size, offset)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(tierChangesResp{model.ResOK, ok, cs}))
+}
+
+type reparentResp struct {
+	RespCode    string               `json:"respCode"`
+	RespMsg     string               `json:"respMsg"`
+	MemberID    string               `json:"id"`
+	ChangeID    int                  `json:"changeid"`
+	Descendants int                  `json:"descendants"`
+	Levels      int                  `json:"levels"`
+	Rebates     int                  `json:"rebates"`
+	Clawback    model.Money          `json:"clawback"`
+	Reissued    model.Money          `json:"reissued"`
+	CapsHit     []model.RebateCapHit `json:"capshit,omitempty"`
+}
+
+//Reparent 调整会员推荐人, 会员及其全部下级一并移至新推荐人下, 管理接口
+//  id        : 被调整会员id
+//  refid     : 新推荐会员id, 不能是会员自己或其下级
+//  reason    : 调整原因
+//  recompute : bool 按代数将历史返利转给新关系中的上级, 缺省否(历史返利不变)
+//  unit      : 金额单位, fen|yuan, 缺省fen
+//  return:
+//    code = "200" 成功, descendants 一并移动的下级人数, levels 重建的关系记录数
+//                 rebates, reissued 转给新上级的返利笔数及金额, clawback 自原上级扣回的返利
+//                 capshit 转给新上级时触发的返利上限, 超出上限的返利只扣回不转移
+//    code = "401" 无管理权限
+//    code = "404" 会员不存在
+//    code = "412" 参数错误, 推荐人未变更或循环推荐
+//    code = "500" 内部错误
+func (c *Controller) Reparent(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	unit, valid := getUnit(r)
+	if !valid {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "无效金额单位"}))
+		return
+	}
+	recompute, _ := strconv.ParseBool(getPara(r, "recompute"))
+	id := getPara(r, "id")
+	result, code, msg := model.Reparent(app.App.DB, id, getPara(r, "refid"), getPara(r, "reason"), recompute)
+	if code != model.ResOK {
+		fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+		return
+	}
+	resp := reparentResp{model.ResOK, ok, id, result.ChangeID, result.Descendants, result.Levels,
+		result.Rebates, result.Clawback.To(unit), result.Reissued.To(unit), result.CapsHit}
+	for i := range resp.CapsHit {
+		resp.CapsHit[i].Cut = resp.CapsHit[i].Cut.To(unit)
+	}
+	fmt.Fprintf(w, jsonString(resp))
+}
+
+type unbindResp struct {
+	RespCode    string `json:"respCode"`
+	RespMsg     string `json:"respMsg"`
+	MemberID    string `json:"id"`
+	ChangeID    int    `json:"changeid"`
+	Descendants int    `json:"descendants"`
+	Levels      int    `json:"levels"`
+	RebindAfter string `json:"rebindAfter,omitempty"`
+}
+
+//Unbind 解除会员与推荐人的关系, 会员及其全部下级成为独立的树, 管理接口
+//  id     : 会员id
+//  reason : 解绑原因, 必填
+//  days   : 冷静期天数, 期间不能重新绑定推荐人; 缺省按RebindCoolingDays配置, 0不限制
+//  return:
+//    code = "200" 成功, descendants 一并脱离的下级人数, levels 删除的关系记录数, rebindAfter 冷静期截止时间
+//    code = "401" 无管理权限
+//    code = "404" 会员不存在
+//    code = "412" 参数错误或会员无推荐用户
+//    code = "500" 内部错误
+func (c *Controller) Unbind(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	result, code, msg := model.Unbind(app.App.DB, id, getPara(r, "reason"), getPara(r, "days"))
+	if code != model.ResOK {
+		fmt.Fprintf(w, jsonString(&msgResp{code, msg}))
+		return
+	}
+	resp := unbindResp{model.ResOK, ok, id, result.ChangeID, result.Descendants, result.Levels, ""}
+	if result.RebindAfter != nil {
+		resp.RebindAfter = result.RebindAfter.Format("2006-01-02 15:04")
+	}
+	fmt.Fprintf(w, jsonString(resp))
+}
+
+type referenceChangesResp struct {
+	RespCode string                        `json:"respCode"`
+	RespMsg  string                        `json:"respMsg"`
+	Changes  []model.ReferenceChangeOutput `json:"changes"`
+}
+
+//ReferenceChanges 推荐关系变更记录, 管理接口
+//  id      : memberid, 空为全部会员
+//  pagesize: 每页记录数, 缺省500
+//  offset  : 偏移
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) ReferenceChanges(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	size, _ := strconv.Atoi(getPara(r, "pagesize"))
+	offset, _ := strconv.Atoi(getPara(r, "offset"))
+	cs, err := model.GetReferenceChanges(app.App.DB, getPara(r, "id"), size, offset)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(referenceChangesResp{model.ResOK, ok, cs}))
+}
+
+type ledgerResp struct {
+	RespCode string                `json:"respCode"`
+	RespMsg  string                `json:"respMsg"`
+	Balances []model.LedgerBalance `json:"balances"`
+}
+
+//Ledger 试算平衡, 各会计科目余额(借方为正), 合计应为0
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) Ledger(w http.ResponseWriter, r *http.Request) {
+	lbs, err := model.LedgerBalances(app.App.DB)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	fmt.Fprintf(w, jsonString(ledgerResp{model.ResOK, ok, lbs}))
+}
+
+type reconcileResp struct {
+	RespCode      string              `json:"respCode"`
+	RespMsg       string              `json:"respMsg"`
+	Discrepancies []model.Discrepancy `json:"discrepancies"`
+}
+
+//Reconcile 对账, 比较会员账户记录,交易记录,会员钱包科目及user_levels关系
+//  fix    : bool 是否写入调整, 缺省否
+//  format : json|csv, 缺省json
+//  return:
+//    code = "200" 成功
+//    code = "401" 无管理权限
+//    code = "500" 内部错误
+func (c *Controller) Reconcile(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	fix, _ := strconv.ParseBool(getPara(r, "fix"))
+	ds, err := model.Reconcile(app.App.DB, fix)
+	if err != nil {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	if getPara(r, "format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		model.WriteDiscrepanciesCSV(w, ds)
+		return
+	}
+	fmt.Fprintf(w, jsonString(reconcileResp{model.ResOK, ok, ds}))
+}
+
+type treeResp struct {
+	RespCode string `json:"respCode"`
+	RespMsg  string `json:"respMsg"`
+	*model.Tree
+}
+
+//Tree 导出会员下级树, 管理接口
+//  id     : memberid
+//  depth  : 导出深度(代数), 缺省或0不限
+//  limit  : 每页节点数, 缺省10000; 节点按深度分页, 很宽的树可分多页导出
+//  offset : 偏移
+//  format : json|csv|dot, 缺省json; json为嵌套结构, csv每个节点一行(含各代路径), dot为Graphviz格式
+//  return:
+//    code = "200" 成功, total 导出深度内节点总数, truncated 之后还有节点
+//    code = "401" 无管理权限
+//    code = "404" 会员不存在
+//    code = "412" 参数不足
+//    code = "500" 内部错误
+func (c *Controller) Tree(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm() //解析参数，默认是不会解析的
+	id := getPara(r, "id")
+	if len(id) == 0 {
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResInvalid, "参数不足"}))
+		return
+	}
+	depth, _ := strconv.Atoi(getPara(r, "depth"))
+	limit, _ := strconv.Atoi(getPara(r, "limit"))
+	offset, _ := strconv.Atoi(getPara(r, "offset"))
+	t, err := model.ExportTree(app.App.DB, id, depth, limit, offset)
+	if err != nil {
+		if sql.ErrNoRows == err {
+			fmt.Fprintf(w, jsonString(getMsgRespByCode(model.ResNotFound)))
+			return
+		}
+		fmt.Fprintf(w, jsonString(&msgResp{model.ResFail, err.Error()}))
+		return
+	}
+	switch getPara(r, "format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		model.WriteTreeCSV(w, t)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		model.WriteTreeDOT(w, t)
+	default:
+		fmt.Fprintf(w, jsonString(treeResp{model.ResOK, ok, t}))
+	}
+}
+
 func getMsgRespByCode(code string) *msgResp {
 	var msg string
 	switch code {
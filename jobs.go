@@ -0,0 +1,33 @@
+package main
+
+import (
+	"./app"
+	"./model"
+	"github.com/e2u/goboot"
+)
+
+//ExpireJob 过期积分清理任务
+type ExpireJob struct{}
+
+//Run 执行过期积分清理
+func (j ExpireJob) Run() {
+	n, err := model.ExpireAccounts(app.App.DB)
+	if err != nil {
+		goboot.Log.Errorf("expire job error after %d accounts: %v", n, err)
+		return
+	}
+	goboot.Log.Infof("expire job done, %d accounts expired", n)
+}
+
+//TierJob 会员等级评定任务
+type TierJob struct{}
+
+//Run 按等级评定规则更新会员等级
+func (j TierJob) Run() {
+	n, err := model.EvaluateTiers(app.App.DB)
+	if err != nil {
+		goboot.Log.Errorf("tier job error: %v", err)
+		return
+	}
+	goboot.Log.Infof("tier job done, %d members changed", n)
+}
@@ -72,13 +72,23 @@ func srvMain(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	if flag.NArg() > 0 { //子命令, 执行完退出
+		code := runCommand(flag.Args())
+		app.Close()
+		os.Exit(code)
+	}
 	jobs.SelfConcurrent = false // 不允许并发,只能运行完一个任务再运行下一个任务
 	//	go jobs.Every(time.Minute, HealthJob{})
+	go jobs.Every(time.Duration(goboot.Config.MustInt("jobs.expire.minutes", 60))*time.Minute, ExpireJob{})
+	go jobs.Every(time.Duration(goboot.Config.MustInt("jobs.tier.minutes", 1440))*time.Minute, TierJob{})
 
 	c := &controller.Controller{}
 	r := mux.NewRouter()
 	r.HandleFunc("/cashout", c.Cashout)
 	r.HandleFunc("/consume", c.Consume)
+	r.HandleFunc("/refund", c.Refund)
+	r.HandleFunc("/transfer", c.Transfer)
+	r.HandleFunc("/recharge", c.Recharge)
 	r.HandleFunc("/adduser", c.AddUser)
 	r.HandleFunc("/updateuser", c.UpdateUser)
 	r.HandleFunc("/checkuser", c.Members)
@@ -87,8 +97,30 @@ func main() {
 	r.HandleFunc("/consumehistory", c.ConsumeHistory)
 	r.HandleFunc("/bind", c.Bind)
 	r.HandleFunc("/reference", c.Reference)
+	r.HandleFunc("/ancestors", c.Ancestors)
 	r.HandleFunc("/getratio", c.GetRatio)
 	r.HandleFunc("/setratio", c.SetRatio)
+	r.HandleFunc("/ledger", controller.AdminOnly(c.Ledger))
+	r.HandleFunc("/reconcile", controller.AdminOnly(c.Reconcile))
+	r.HandleFunc("/adjust", controller.AdminOnly(c.Adjust))
+	r.HandleFunc("/cashoutlist", controller.AdminOnly(c.CashoutList))
+	r.HandleFunc("/cashoutaction", controller.AdminOnly(c.CashoutAction))
+	r.HandleFunc("/cashouthistory", controller.AdminOnly(c.CashoutHistory))
+	r.HandleFunc("/cashoutpolicies", controller.AdminOnly(c.CashoutPolicies))
+	r.HandleFunc("/setcashoutpolicy", controller.AdminOnly(c.SetCashoutPolicy))
+	r.HandleFunc("/rebaterules", controller.AdminOnly(c.RebateRules))
+	r.HandleFunc("/setrebaterule", controller.AdminOnly(c.SetRebateRule))
+	r.HandleFunc("/rechargetiers", controller.AdminOnly(c.RechargeTiers))
+	r.HandleFunc("/setrechargetier", controller.AdminOnly(c.SetRechargeTier))
+	r.HandleFunc("/campaigns", controller.AdminOnly(c.Campaigns))
+	r.HandleFunc("/setcampaign", controller.AdminOnly(c.SetCampaign))
+	r.HandleFunc("/tierrules", controller.AdminOnly(c.TierRules))
+	r.HandleFunc("/settierrule", controller.AdminOnly(c.SetTierRule))
+	r.HandleFunc("/tierchanges", controller.AdminOnly(c.TierChanges))
+	r.HandleFunc("/reparent", controller.AdminOnly(c.Reparent))
+	r.HandleFunc("/unbind", controller.AdminOnly(c.Unbind))
+	r.HandleFunc("/tree", controller.AdminOnly(c.Tree))
+	r.HandleFunc("/referencechanges", controller.AdminOnly(c.ReferenceChanges))
 	r.HandleFunc("/", srvMain) //设置访问的路由
 	loggedRouter := handlers.LoggingHandler(os.Stdout, r)
 	srv := &http.Server{
@@ -1,8 +1,9 @@
 package model
 
 import (
+	"database/sql"
 	"errors"
-	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -30,18 +31,41 @@ type Account struct {
 	GetDate    time.Time       `gorm:"column:getdate"`
 	GetAmount  decimal.Decimal `gorm:"column:getamount"`
 	UpdTime    time.Time       `gorm:"column:updtime"`
+	//TransactionID 产生该记录的交易id
+	TransactionID sql.NullString `gorm:"column:transaction_id"`
+	//WalletType 钱包类型, 见walletRules
+	WalletType string `gorm:"column:wallettype"`
+	//Used 本次操作扣减金额, 不存库
+	Used decimal.Decimal `gorm:"-"`
+}
+
+//AccountUsage 交易对账户记录的扣减明细, 用于冲正时恢复原账户记录
+type AccountUsage struct {
+	ID            string          `gorm:"column:id"`
+	TransactionID string          `gorm:"column:transaction_id"`
+	AccountID     string          `gorm:"column:account_id"`
+	Amount        decimal.Decimal `gorm:"column:amount"`
+	UpdTime       time.Time       `gorm:"column:updtime"`
 }
 
 //ConsumeResult 消费接口返回结果
 type ConsumeResult struct {
 	//PointUsed 消耗抵用金额
-	PointUsed string
+	PointUsed Money
 	//PayAmount 剩余需支付
-	PayAmount string
+	PayAmount Money
 	//SelfGainPoints 为自己累积奖励金额
-	SelfGainPoints string
+	SelfGainPoints Money
 	//GainPoints 总累积奖励金额
-	GainPoints string
+	GainPoints Money
+	//RequestID 提现申请id
+	RequestID string
+	//Fee 提现手续费
+	Fee Money
+	//CapsHit 触发的返利上限
+	CapsHit []RebateCapHit
+	//Campaigns 产生活动返利的促销活动id
+	Campaigns []int
 }
 
 //NewAccount 空Account
@@ -49,17 +73,18 @@ func NewAccount() *AccountPoint {
 	return &AccountPoint{}
 }
 
-//GetAmountByMember 获取有效账户余额 by member //todo
-// valid: true 有效期内; false 未到有效期的,不含过期的
+//GetAmountByMember 获取有效账户余额 by member
+// valid: true 有效期内,扣除欠款(负数记录); false 待到账(到账期限内)的,不含过期的
 func GetAmountByMember(db *gorm.DB, mid string, valid bool) (decimal.Decimal, error) {
 	a := AccountPoint{}
-	var sql string
+	var sql, debt string
 	if valid {
 		sql = ">="
+		debt = " or amount<0"
 	} else {
 		sql = "<"
 	}
-	db1 := db.Table("accounts").Select("member_id,sum(amount) as sumamount").Where("current_date "+sql+" startdate and ((current_date<=expiredate) or (expiredate is null)) and amount>0 and member_id=?", mid).Group("member_id").First(&a)
+	db1 := db.Table("accounts").Select("member_id,sum(amount) as sumamount").Where("((current_date "+sql+" startdate and ((current_date<=expiredate) or (expiredate is null)) and amount>0)"+debt+") and member_id=?", mid).Group("member_id").First(&a)
 	if db1.RecordNotFound() {
 		return zero, nil
 	}
@@ -71,18 +96,53 @@ func GetAmountByMember(db *gorm.DB, mid string, valid bool) (decimal.Decimal, er
 	return a.Amount, nil
 }
 
-//Cashout 消费金额
+//Cashout 提现申请, 扣减(冻结)积分并创建待审批提现申请, 审批流程见CashoutAction
 // 	m member
 //  amountStr	金额字符串形式, 分为单位,例, 120 1块2毛
 //  order:订单id
-//	return point, code, message
-func Cashout(db *gorm.DB, m *Member, amountStr string, orderID string) (string, string, string) {
+//  idemKey:幂等键, 空时使用订单号; 均为空时不做幂等检查
+//	单一事务内完成, 锁定会员记录, 同一会员的积分扣减串行执行
+//	重复请求返回原结果, 请求内容不一致返回ResConflict
+//	按会员等级适用的提现规则检查金额及日/月上限, 手续费另行扣减
+//	return result, code, message
+func Cashout(db *gorm.DB, m *Member, amountStr string, orderID string, idemKey string) (*ConsumeResult, string, string) {
 	amount, err := decimal.NewFromString(amountStr)
 	if err != nil {
-		return "", ResInvalid, err.Error()
+		return nil, ResInvalid, err.Error()
+	}
+	tx := db.Begin() //开启事务
+	result, code, msg := cashout(tx, m, amount, orderID, idemKey)
+	if code != ResOK {
+		tx.Rollback()
+		return nil, code, msg
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	return result, code, msg
+}
+
+func cashout(tx *gorm.DB, m *Member, amount decimal.Decimal, orderID string, idemKey string) (*ConsumeResult, string, string) {
+	if err := lockMember(tx, m.ID); err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	if len(idemKey) == 0 {
+		idemKey = orderID
 	}
-	var validcode int
-	validcode, err = vaildOrderID(db, m.ID, orderID)
+	hash := requestHash(amount.String(), orderID)
+	if len(idemKey) > 0 {
+		r, err := checkIdempotency(tx, m.ID, idemKey, TranCashout, hash)
+		if err == ErrIdempotencyConflict {
+			return nil, ResConflict, err.Error()
+		}
+		if err != nil {
+			return nil, ResWrongSQL, err.Error()
+		}
+		if r != nil {
+			return r, ResOK, "OK"
+		}
+	}
+	validcode, err := vaildOrderID(tx, m.ID, orderID)
 	if validcode > 0 {
 		var code, msg string
 		if validcode == 1 {
@@ -92,24 +152,53 @@ func Cashout(db *gorm.DB, m *Member, amountStr string, orderID string) (string,
 			code = ResFail
 			msg = err.Error()
 		}
-		return "", code, msg
+		return nil, code, msg
 	}
-	var point decimal.Decimal
-	var t *Transaction
-	var consumedPoints []Account
-	amount, point, t, consumedPoints = getConsumeAccount(db, m.ID, amount, orderID)
+	fee, code, msg := checkCashoutPolicy(tx, m, amount)
+	if code != ResOK {
+		return nil, code, msg
+	}
+	remind, _, t, consumedPoints := getConsumeAccount(tx, m.ID, amount.Add(fee), orderID, TranCashout)
 	if t == nil {
-		return "", ResWrongSQL, "积分消耗错误"
+		return nil, ResWrongSQL, "积分消耗错误"
 	}
-	if amount.IsPositive() {
-		return "", ResInvalid, "余额不足"
+	if remind.IsPositive() {
+		return nil, ResInvalid, "余额不足"
 	}
+	//手续费单独记交易, 扣减明细按手续费拆分
+	t.Amount = amount.Neg()
+	feeUsages := splitFeeUsages(consumedPoints, fee)
 	ts := make([]Transaction, 0) //兼容saveConsume, 空数据集
 	as := make([]Account, 0)     //兼容saveConsume, 空数据集
-	if false == saveConsume(db, ts, as, t, consumedPoints) {
-		return "", ResWrongSQL, "保存错误"
+	o := newOrder(m.ID, orderID, TranCashout, amount, amount, zero)
+	if err = saveConsume(tx, o, ts, as, t, consumedPoints); err != nil {
+		return nil, ResWrongSQL, "保存错误"
 	}
-	return point.String(), ResOK, "OK"
+	req := newCashoutRequest(m.ID, orderID, amount, t.ID)
+	if fee.IsPositive() {
+		ft := &Transaction{}
+		ft.fillTransaction(orderID, m.ID, m.ID, fee.Neg(), TranCashoutFee)
+		if err = ft.saveNew(tx); err != nil {
+			return nil, ResWrongSQL, err.Error()
+		}
+		for i := range feeUsages {
+			if err = saveUsage(tx, ft.ID, &feeUsages[i]); err != nil {
+				return nil, ResWrongSQL, err.Error()
+			}
+		}
+		req.Fee = fee
+		req.FeeTransactionID.Scan(ft.ID)
+	}
+	if err = req.saveNew(tx, m.ID); err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	result := &ConsumeResult{PointUsed: NewMoney(amount), RequestID: req.ID, Fee: NewMoney(fee)}
+	if len(idemKey) > 0 {
+		if err = saveIdempotency(tx, m.ID, idemKey, TranCashout, hash, result); err != nil {
+			return nil, ResWrongSQL, err.Error()
+		}
+	}
+	return result, ResOK, "OK"
 }
 
 //Consume 消费金额
@@ -117,17 +206,17 @@ func Cashout(db *gorm.DB, m *Member, amountStr string, orderID string) (string,
 //  amountStr	金额字符串形式, 分为单位,例, 120 1块2毛
 //  usePoint	是否使用账户金额
 //  order:订单id
-func Consume(db *gorm.DB, m *Member, amountStr string, usePoint string, orderID string) (*ConsumeResult, error) {
+//  idemKey:幂等键, 空时使用订单号; 均为空时不做幂等检查
+//  merchant:商户(门店), 可为空
+//  items:消费明细, 可为空; 非空时金额合计须等于消费金额, 按商户及类别匹配返利规则(见RebateRule)
+//	单一事务内完成, 锁定会员记录, 同一会员的积分扣减串行执行
+//	重复请求返回原结果, 请求内容不一致返回ErrIdempotencyConflict
+func Consume(db *gorm.DB, m *Member, amountStr string, usePoint string, orderID string, idemKey string, merchant string, items []ConsumeItem) (*ConsumeResult, error) {
 	amount, err := decimal.NewFromString(amountStr)
 	if err != nil {
 		return nil, err
 	}
-	var validcode int
-	validcode, err = vaildOrderID(db, m.ID, orderID)
-	if validcode > 0 {
-		if err == nil { //assert(result=1)
-			err = errors.New("order No. exist")
-		}
+	if err = CheckItems(items, amount); err != nil {
 		return nil, err
 	}
 	var isuse bool
@@ -135,11 +224,52 @@ func Consume(db *gorm.DB, m *Member, amountStr string, usePoint string, orderID
 		//if err, isuse=false
 		isuse, _ = strconv.ParseBool(usePoint)
 	} //else isuse=false
+	tx := db.Begin() //开启事务
+	result, err := consume(tx, m, amount, isuse, orderID, idemKey, merchant, items)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return nil, db1.Error
+	}
+	return result, nil
+}
+
+func consume(tx *gorm.DB, m *Member, amount decimal.Decimal, isuse bool, orderID string, idemKey string, merchant string, items []ConsumeItem) (*ConsumeResult, error) {
+	if err := lockMember(tx, m.ID); err != nil {
+		return nil, err
+	}
+	if len(idemKey) == 0 {
+		idemKey = orderID
+	}
+	fields := []string{amount.String(), strconv.FormatBool(isuse), orderID}
+	if len(merchant) > 0 || len(items) > 0 {
+		fields = append(fields, merchant)
+		for _, it := range items {
+			fields = append(fields, it.SKU, it.Category, it.Amount.String())
+		}
+	}
+	hash := requestHash(fields...)
+	if len(idemKey) > 0 {
+		r, err := checkIdempotency(tx, m.ID, idemKey, TranConsume, hash)
+		if err != nil || r != nil {
+			return r, err
+		}
+	}
+	validcode, err := vaildOrderID(tx, m.ID, orderID)
+	if validcode > 0 {
+		if err == nil { //assert(result=1)
+			err = errors.New("order No. exist")
+		}
+		return nil, err
+	}
 	var point decimal.Decimal
 	var t *Transaction
 	var consumedPoints []Account
+	total := amount
 	if isuse {
-		amount, point, t, consumedPoints = getConsumeAccount(db, m.ID, amount, orderID)
+		amount, point, t, consumedPoints = getConsumeAccount(tx, m.ID, amount, orderID, TranConsume)
 		if t == nil {
 			return nil, errors.New("积分消耗错误")
 		}
@@ -147,29 +277,88 @@ func Consume(db *gorm.DB, m *Member, amountStr string, usePoint string, orderID
 
 	//fmt.Println("consume:", point, amount, isuse, len(consumedPoints))
 	//reward := []decimal.Decimal
-	ul, _ := getLevelsByMember(db, m.ID)
+	ul, _ := getLevelsByMember(tx, m.ID)
 	length := len(ul)
 	if length <= 0 {
 		return nil, errors.New("用户关系错误")
 	}
 	ul = ul[:length]
 	//fmt.Println(ul)
-	transactions := createTransactionsByLevels(db, ul, amount, orderID)
-	accounts := getAccountPoints(db, transactions)
-	if true {
-		if false == saveConsume(db, transactions, accounts, t, consumedPoints) {
-			return nil, errors.New("保存错误")
+	lines, err := getRebateLines(tx, merchant, items, total, amount)
+	if err != nil {
+		return nil, err
+	}
+	transactions, rest, err := createTransactionsByLevels(tx, ul, lines, amount, 0, orderID)
+	if err != nil {
+		return nil, err
+	}
+	bonus, err := createCampaignTransactions(tx, m, merchant, ul, lines, amount, orderID)
+	if err != nil {
+		return nil, err
+	}
+	transactions = append(transactions, bonus...)
+	transactions, hits, err := applyRebateCaps(tx, transactions)
+	if err != nil {
+		return nil, err
+	}
+	residual := rebateResidual(transactions, rest, rebateRounding(tx), GetSettingString(tx, RebateResidual, ResidualLast))
+	accounts := getAccountPoints(tx, transactions)
+	o := newOrder(m.ID, orderID, TranConsume, total, point, amount)
+	if err = saveConsume(tx, o, transactions, accounts, t, consumedPoints); err != nil {
+		return nil, errors.New("保存错误")
+	}
+	if len(transactions) > 0 {
+		if err = postRebateResidual(tx, transactions[len(transactions)-1].ID, residual); err != nil {
+			return nil, err
 		}
 	}
-	a := "0"
-	if len(accounts) > 0 {
-		a = accounts[0].Amount.Round(0).String()
+	a := zero
+	for _, t := range transactions {
+		if t.TargetID == m.ID {
+			a = a.Add(t.Amount)
+		}
 	}
 
-	result := &ConsumeResult{point.Round(0).String(), amount.Round(0).String(), a, totalAmount(accounts).Round(0).String()}
+	result := &ConsumeResult{PointUsed: NewMoney(point), PayAmount: NewMoney(amount), SelfGainPoints: NewMoney(a),
+		GainPoints: NewMoney(totalAmount(accounts)), Fee: NewMoney(zero), CapsHit: hits, Campaigns: campaignIDs(transactions)}
+	if len(idemKey) > 0 {
+		if err = saveIdempotency(tx, m.ID, idemKey, TranConsume, hash, result); err != nil {
+			return nil, err
+		}
+	}
 	return result, nil
 }
 
+//lockMember 锁定会员记录(SELECT ... FOR UPDATE), 直至事务结束;
+//	所有扣减账户记录的操作先锁定会员, 多实例部署时同样保证同一会员串行
+func lockMember(tx *gorm.DB, mID string) error {
+	return tx.Exec("SELECT id FROM members WHERE id=? FOR UPDATE", mID).Error
+}
+
+//lockMembers 按id顺序锁定多个会员记录, 并发事务按相同顺序加锁, 避免死锁
+func lockMembers(tx *gorm.DB, ids []string) error {
+	for _, id := range sortedIDs(ids) {
+		if err := lockMember(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//sortedIDs 去重并排序的id
+func sortedIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	rs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			rs = append(rs, id)
+		}
+	}
+	sort.Strings(rs)
+	return rs
+}
+
 func totalAmount(as []Account) (total decimal.Decimal) {
 	total = zero
 	for _, a := range as {
@@ -180,24 +369,108 @@ func totalAmount(as []Account) (total decimal.Decimal) {
 
 //getConsumeAccount 获取消费账户对应记录列表,产生交易记录,计算消耗金额
 //	返回值依次: 剩余需支付消费金额,抵用金额,交易记录对象,储值更新对象列表
+//	tranType 交易类型, TranConsume/TranCashout, 按交易类型可扣减的钱包(walletsFor)扣减
+//	有欠款(负数账户记录)时, 抵用金额以扣除欠款后的余额为上限, 并同时冲抵欠款
 //	t=nil until all exceptions unhappened
 //	check t == nil
-func getConsumeAccount(db *gorm.DB, mID string, amount decimal.Decimal, orderID string) (remindAmount decimal.Decimal, point decimal.Decimal, t *Transaction, result []Account) {
+func getConsumeAccount(db *gorm.DB, mID string, amount decimal.Decimal, orderID string, tranType string) (remindAmount decimal.Decimal, point decimal.Decimal, t *Transaction, result []Account) {
+	return getWalletAccount(db, mID, amount, orderID, tranType, walletsFor(tranType))
+}
+
+//getWalletAccount 同getConsumeAccount, 按wallets顺序扣减指定钱包
+func getWalletAccount(db *gorm.DB, mID string, amount decimal.Decimal, orderID string, tranType string, wallets []string) (remindAmount decimal.Decimal, point decimal.Decimal, t *Transaction, result []Account) {
+	now := time.Now()
+	var debts []Account
+	db1 := db.Set("gorm:query_option", "FOR UPDATE").Find(&debts, "amount<0 and member_id=?", mID)
+	if db1.Error != nil {
+		goboot.Log.Error(db1.Error)
+		return
+	}
+	debt := zero
+	for i := range debts {
+		debt = debt.Sub(debts[i].Amount)
+	}
+	balance, err := getAmountByWallets(db, mID, wallets) //已扣除欠款
+	if err != nil {
+		goboot.Log.Error(err)
+		return
+	}
+	wallet := walletOf(nil)
+	draw := amount
+	if balance.LessThan(draw) {
+		draw = balance
+	}
+	if draw.IsNegative() {
+		draw = zero
+	}
+	if draw.IsPositive() && debt.IsPositive() {
+		var remind decimal.Decimal
+		remind, result, err = drawAccounts(db, mID, draw.Add(debt), wallets)
+		if err != nil {
+			goboot.Log.Error(err)
+			return
+		}
+		draw = draw.Sub(remind)
+		wallet = walletOf(result)
+		for i := range debts {
+			debts[i].Used = debts[i].Amount
+			debts[i].Amount = zero
+			debts[i].UpdTime = now
+		}
+		result = append(result, debts...)
+	} else if draw.IsPositive() {
+		var remind decimal.Decimal
+		remind, result, err = drawAccounts(db, mID, draw, wallets)
+		if err != nil {
+			goboot.Log.Error(err)
+			return
+		}
+		draw = draw.Sub(remind)
+		wallet = walletOf(result)
+	}
+	point = draw
+	remindAmount = amount.Sub(point)
+	t = new(Transaction)
+	t.fillTransaction(orderID, mID, mID, point.Neg(), tranType)
+	t.WalletType = wallet
+	//fmt.Println(len(result), remindAmount, point, amount, result[len(result)-1].Amount, t)
+	return remindAmount, point, t, result
+}
+
+//drawAccounts 按wallets顺序扣减用户各钱包的有效账户记录, 见drawWallet
+//	返回值依次: 未能扣减的剩余金额, 被扣减的账户记录(Used为本次扣减金额), error
+func drawAccounts(db *gorm.DB, mID string, amount decimal.Decimal, wallets []string) (remindAmount decimal.Decimal, result []Account, err error) {
+	remindAmount = amount
+	for _, w := range wallets {
+		if !remindAmount.IsPositive() {
+			break
+		}
+		var as []Account
+		if remindAmount, as, err = drawWallet(db, mID, remindAmount, w); err != nil {
+			return remindAmount, nil, err
+		}
+		result = append(result, as...)
+	}
+	return remindAmount, result, nil
+}
+
+//drawWallet 扣减用户一个钱包的有效账户记录, 有效期优先,次优先小金额
+//	须在事务内调用, 选中的账户记录以FOR UPDATE锁定
+//	返回值依次: 未能扣减的剩余金额, 被扣减的账户记录(Used为本次扣减金额), error
+func drawWallet(db *gorm.DB, mID string, amount decimal.Decimal, wallet string) (remindAmount decimal.Decimal, result []Account, err error) {
 	LIMITATION := 4 //常量
 	offset := 0
 	now := time.Now()
 	var as []Account
-	//var result []Account
 	remindAmount = amount
 	for remindAmount.GreaterThan(zero) {
 		//order by 优先有效期,次优先小amount
-		db1 := db.Order("expiredate, amount").Offset(offset).Limit(LIMITATION).Find(&as, "current_date >= startdate and ((current_date<=expiredate) or (expiredate is null)) and amount>0 and member_id=?", mID)
+		db1 := db.Set("gorm:query_option", "FOR UPDATE").Order("expiredate, amount").Offset(offset).Limit(LIMITATION).Find(&as, "current_date >= startdate and ((current_date<=expiredate) or (expiredate is null)) and amount>0 and member_id=? and wallettype=?", mID, wallet)
 		if db1.RecordNotFound() {
 			break
 		}
 		if db1.Error != nil {
-			goboot.Log.Error(db1.Error)
-			return
+			return remindAmount, nil, db1.Error
 		}
 		var i int
 		var a Account
@@ -207,10 +480,12 @@ func getConsumeAccount(db *gorm.DB, mID string, amount decimal.Decimal, orderID
 			if remindAmount.LessThanOrEqual(a.Amount) {
 				as = as[:i+1]
 				as[i].Amount = a.Amount.Sub(remindAmount)
+				as[i].Used = remindAmount
 				remindAmount = zero
 				break
 			}
 			remindAmount = remindAmount.Sub(a.Amount)
+			as[i].Used = a.Amount
 			as[i].Amount = zero
 		}
 		result = append(result, as...)
@@ -219,63 +494,80 @@ func getConsumeAccount(db *gorm.DB, mID string, amount decimal.Decimal, orderID
 		}
 		offset += LIMITATION
 	}
-	point = amount.Sub(remindAmount)
-	t = new(Transaction)
-	t.fillTransaction(orderID, mID, mID, point.Neg())
-	//fmt.Println(len(result), remindAmount, point, amount, result[len(result)-1].Amount, t)
-	return remindAmount, point, t, result
+	return remindAmount, result, nil
 }
 
-func saveConsume(db *gorm.DB, transactions []Transaction, accounts []Account, t *Transaction, consumedPoints []Account) bool {
-	tx := db.Begin() //开启事务
-
+//saveConsume 保存消费/提现产生的订单,账户记录,交易记录; 在调用方事务内执行
+//	o 订单, 为nil时不保存
+func saveConsume(tx *gorm.DB, o *Order, transactions []Transaction, accounts []Account, t *Transaction, consumedPoints []Account) error {
+	if o != nil {
+		if err := o.saveNew(tx); err != nil {
+			goboot.Log.Error("consume save error.")
+			return err
+		}
+	}
 	// fmt.Println("account:", len(accounts))
 	for _, a := range accounts {
 		if err := a.saveNew(tx); err != nil {
-			tx.Rollback()
-			goboot.Log.Error("consume save error, rollback.")
-			return false
+			goboot.Log.Error("consume save error.")
+			return err
 		}
 	}
 	// fmt.Println("points:", len(consumedPoints))
 	for _, a := range consumedPoints {
 		db1 := tx.Save(a)
 		if db1.Error != nil {
-			tx.Rollback()
 			goboot.Log.Error(db1.Error)
-			return false
+			return db1.Error
+		}
+		if t != nil {
+			if err := saveUsage(tx, t.ID, &a); err != nil {
+				goboot.Log.Error(err)
+				return err
+			}
 		}
 	}
 	// fmt.Println("trans:", len(transactions))
 	for _, a := range transactions {
 		if err := a.saveNew(tx); err != nil {
-			tx.Rollback()
-			goboot.Log.Error("consume save error, rollback.")
-			return false
+			goboot.Log.Error("consume save error.")
+			return err
 		}
 	}
 	if t != nil {
 		//fmt.Println("tran")
 		if err := t.saveNew(tx); err != nil {
-			tx.Rollback()
-			goboot.Log.Error("consume save error, rollback.")
-			return false
+			goboot.Log.Error("consume save error.")
+			return err
 		}
 	}
-	tx.Commit()
 	//fmt.Println("consume done")
-	return true
-
+	return nil
 }
+
 func (a *Account) saveNew(db *gorm.DB) error {
 	db.Create(a)
 	if db.NewRecord(a) {
 		return errors.New("交易创建失败")
 	}
-	fmt.Println("done:", a)
 	return nil
 }
 
+//saveUsage 保存交易对账户记录的扣减明细
+func saveUsage(db *gorm.DB, tID string, a *Account) error {
+	if a.Used.Equal(zero) {
+		return nil
+	}
+	u := &AccountUsage{uuid.NewV4().String(), tID, a.ID, a.Used, a.UpdTime}
+	db.Create(u)
+	if db.NewRecord(u) {
+		return errors.New("扣减明细创建失败")
+	}
+	return nil
+}
+
+//getAccountPoints 交易记录对应的新账户记录
+//	返利按钱包的到账期限(availableDays)生效, 其他交易即时生效; 有效期自生效日起计算
 func getAccountPoints(db *gorm.DB, ts []Transaction) []Account {
 	arr := make([]Account, len(ts))
 	if len(ts) <= 0 {
@@ -283,17 +575,36 @@ func getAccountPoints(db *gorm.DB, ts []Transaction) []Account {
 	}
 	//mid := ts[0].SourceID
 	now := time.Now()
-	tday := now.AddDate(0, 0, AvailableDays)
+	days := map[string]int{}
+	validDays := GetSettingInt(db, PointValidDays, 0) //新产生积分的有效天数, 自生效日起计算
 	for i, t := range ts {
 		arr[i].ID = uuid.NewV4().String()
 		arr[i].MemberID = t.TargetID
 		arr[i].Amount = t.Amount
-		//arr[i].ExpireDate = &tomorrow
-		//arr[i].ExpireDate leave null
-		arr[i].StartDate = tday
 		arr[i].GetDate = now
 		arr[i].GetAmount = t.Amount
 		arr[i].UpdTime = now
+		arr[i].TransactionID.Scan(t.ID)
+		arr[i].WalletType = t.WalletType
+		if !ValidWallet(t.WalletType) {
+			arr[i].WalletType = WalletRebate
+		}
+		tday := now
+		if t.TranType == TranRebate {
+			n, ok := days[arr[i].WalletType]
+			if !ok {
+				n = availableDays(db, arr[i].WalletType)
+				days[arr[i].WalletType] = n
+			}
+			if n > 0 {
+				tday = now.AddDate(0, 0, n)
+			}
+		}
+		arr[i].StartDate = tday
+		if validDays > 0 { //PointValidDays<=0 时为null, 永不过期
+			d := tday.AddDate(0, 0, validDays)
+			arr[i].ExpireDate = &d
+		}
 	}
 	return arr
 }
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//AdjustCredit 调增
+	AdjustCredit = "credit"
+	//AdjustDebit 调减
+	AdjustDebit = "debit"
+)
+
+var (
+	//AdjustReasons 调整原因代码
+	AdjustReasons = map[string]string{
+		"compensation": "客诉补偿",
+		"correction":   "差错更正",
+		"goodwill":     "关怀赠送",
+		"fraud":        "违规扣除",
+		"other":        "其他",
+	}
+)
+
+//Adjustment 人工调整记录, 关联调整交易
+type Adjustment struct {
+	ID            string         `gorm:"column:id"`
+	TransactionID string         `gorm:"column:transaction_id"`
+	MemberID      string         `gorm:"column:member_id"`
+	Reason        string         `gorm:"column:reason"`
+	Operator      string         `gorm:"column:operator"`
+	Remark        sql.NullString `gorm:"column:remark"`
+	CreateTime    time.Time      `gorm:"column:createtime"`
+}
+
+//Adjust 人工调整积分
+// 	m member
+//  direction	AdjustCredit/AdjustDebit
+//  amountStr	金额字符串形式, 分为单位,例, 120 1块2毛
+//  reason	调整原因代码, 见AdjustReasons
+//  operator	操作员id
+//  remark	备注, 可为空
+//  expire	调增积分的过期日, nil时按PointValidDays
+//  wallet	钱包类型, 调增缺省WalletRebate; 调减为空时按扣减顺序扣减全部钱包
+//	调减按积分消费顺序扣减账户记录, 余额不足时失败
+//	return transaction id, code, message
+func Adjust(db *gorm.DB, m *Member, direction string, amountStr string, reason string, operator string, remark string, expire *time.Time, wallet string) (string, string, string) {
+	amount, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return "", ResInvalid, err.Error()
+	}
+	if !amount.IsPositive() {
+		return "", ResInvalid, "调整金额必须大于0"
+	}
+	if _, ok := AdjustReasons[reason]; !ok {
+		return "", ResInvalid, "无效调整原因" + reason
+	}
+	if len(operator) == 0 {
+		return "", ResInvalid, "操作员不能为空"
+	}
+	if len(wallet) > 0 && !ValidWallet(wallet) {
+		return "", ResInvalid, "无效钱包类型" + wallet
+	}
+	tx := db.Begin() //开启事务
+	if err = lockMember(tx, m.ID); err != nil {
+		tx.Rollback()
+		return "", ResWrongSQL, err.Error()
+	}
+	var t *Transaction
+	switch direction {
+	case AdjustCredit:
+		t = &Transaction{}
+		t.fillTransaction("", m.ID, m.ID, amount, TranAdjust)
+		if len(wallet) > 0 {
+			t.WalletType = wallet
+		}
+		as := getAccountPoints(tx, []Transaction{*t})
+		if expire != nil {
+			as[0].ExpireDate = expire
+		}
+		err = saveConsume(tx, nil, []Transaction{*t}, as, nil, nil)
+	case AdjustDebit:
+		var remind decimal.Decimal
+		var consumedPoints []Account
+		wallets := walletsFor(TranAdjust)
+		if len(wallet) > 0 {
+			wallets = []string{wallet}
+		}
+		remind, _, t, consumedPoints = getWalletAccount(tx, m.ID, amount, "", TranAdjust, wallets)
+		if t == nil {
+			err = errors.New("积分扣减错误")
+		} else if remind.IsPositive() {
+			tx.Rollback()
+			return "", ResInvalid, "余额不足"
+		} else {
+			err = saveConsume(tx, nil, nil, nil, t, consumedPoints)
+		}
+	default:
+		tx.Rollback()
+		return "", ResInvalid, "无效调整方向" + direction
+	}
+	if err != nil {
+		tx.Rollback()
+		return "", ResWrongSQL, err.Error()
+	}
+	ad := &Adjustment{uuid.NewV4().String(), t.ID, m.ID, reason, operator, sql.NullString{}, time.Now()}
+	if len(remark) > 0 {
+		ad.Remark.Scan(remark)
+	}
+	if db1 := tx.Create(ad); db1.Error != nil {
+		tx.Rollback()
+		return "", ResWrongSQL, db1.Error.Error()
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return "", ResWrongSQL, db1.Error.Error()
+	}
+	return t.ID, ResOK, "OK"
+}
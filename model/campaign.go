@@ -0,0 +1,277 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//campaignTimeLayout 活动起止时间格式
+	campaignTimeLayout = "2006-01-02 15:04"
+)
+
+//Campaign 促销活动, 活动期内(starttime<=消费时间<endtime)符合条件的消费, 在常规返利之外产生活动返利
+//	条件(为空不限): Level 消费会员等级, Branch 消费商户(门店, 同Consume的merchant), NewMember 会员首单
+//	Multiplier 返利倍数, 活动返利 = 常规返利*(倍数-1), 例 2 = 双倍返利
+//	Ratios 替换的各级分成比例(逗号分隔, 同rebate_rules), 活动返利 = 按该比例的返利-常规返利, 排除的类别仍不返利
+//	Multiplier与Ratios二选一; 多个活动同时有效时分别产生活动返利, 活动返利不小于0
+type Campaign struct {
+	ID         int             `gorm:"column:id" json:"id"`
+	Name       string          `gorm:"column:name" json:"name"`
+	StartTime  time.Time       `gorm:"column:starttime" json:"-"`
+	EndTime    time.Time       `gorm:"column:endtime" json:"-"`
+	Level      sql.NullString  `gorm:"column:level" json:"-"`
+	Branch     sql.NullString  `gorm:"column:branch" json:"-"`
+	NewMember  bool            `gorm:"column:newmember" json:"newmember"`
+	Multiplier decimal.Decimal `gorm:"column:multiplier" json:"multiplier"`
+	Ratios     string          `gorm:"column:ratios" json:"ratios"`
+}
+
+//CampaignOutput 促销活动输出
+type CampaignOutput struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Level  string `json:"level"`
+	Branch string `json:"branch"`
+	*Campaign
+}
+
+//consumeHistory 会员此前的消费记录数, 用于首单判断
+//	Orders 消费订单数; Consumes 积分抵用消费交易, 及orders表之前的历史消费交易(交易类型为空且有订单号)数
+type consumeHistory struct {
+	Orders   int `gorm:"column:orders"`
+	Consumes int `gorm:"column:consumes"`
+}
+
+//consumeHistorySQL 会员此前的消费记录数; 参数: 会员id, 订单类型, 会员id, 交易类型
+const consumeHistorySQL = `select (select count(*) from orders where member_id=? and ordertype=?) orders,
+ (select count(*) from transactions where source_id=? and order_id is not null and (trantype=? or trantype='')) consumes`
+
+//firstOrder 本单是否会员首单
+func (h consumeHistory) firstOrder() bool {
+	return h.Orders == 0 && h.Consumes == 0
+}
+
+//eligible 会员在商户merchant的本单消费是否符合活动条件
+func (c *Campaign) eligible(m *Member, merchant string, h consumeHistory) bool {
+	if c.Level.Valid && c.Level.String != m.Level.String {
+		return false
+	}
+	if c.Branch.Valid && c.Branch.String != merchant {
+		return false
+	}
+	return !c.NewMember || h.firstOrder()
+}
+
+//activeCampaigns 当前有效且会员本单符合条件的促销活动, 须在保存本单订单及交易前调用(首单判断)
+func activeCampaigns(db *gorm.DB, m *Member, merchant string) ([]Campaign, error) {
+	var cs []Campaign
+	if db1 := db.Where("starttime<=now() and endtime>now()").Order("id").Find(&cs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	var h consumeHistory
+	for _, c := range cs {
+		if c.NewMember {
+			if db1 := db.Raw(consumeHistorySQL, m.ID, TranConsume, m.ID, TranConsume).Scan(&h); db1.Error != nil {
+				return nil, db1.Error
+			}
+			break
+		}
+	}
+	rs := cs[:0]
+	for i := range cs {
+		if cs[i].eligible(m, merchant, h) {
+			rs = append(rs, cs[i])
+		}
+	}
+	return rs, nil
+}
+
+//amounts 活动下各级返利金额(未舍入), base 为常规返利金额
+func (c *Campaign) amounts(db *gorm.DB, ul []UserLevel, lines []rebateLine, base []decimal.Decimal) ([]decimal.Decimal, error) {
+	if len(c.Ratios) == 0 {
+		es := make([]decimal.Decimal, len(base))
+		for i, b := range base {
+			es[i] = b.Mul(c.Multiplier)
+		}
+		return es, nil
+	}
+	rs, err := parseRatios(c.Ratios)
+	if err != nil {
+		return nil, err
+	}
+	ls := make([]rebateLine, len(lines))
+	for i, l := range lines {
+		ls[i] = rebateLine{Base: l.Base, Ratios: rs}
+	}
+	return levelAmounts(db, ul, ls)
+}
+
+//createCampaignTransactions 按有效促销活动产生活动返利交易记录, 记录活动id
+//	活动返利为 活动下各级返利-常规返利, 按RebateRounding舍入, 不计舍入差额
+//	Ratio 同createTransactionsByLevels, 为活动返利占实付金额pay的比例
+func createCampaignTransactions(db *gorm.DB, m *Member, merchant string, ul []UserLevel, lines []rebateLine, pay decimal.Decimal, orderID string) ([]Transaction, error) {
+	cs, err := activeCampaigns(db, m, merchant)
+	if err != nil || len(cs) == 0 {
+		return nil, err
+	}
+	total := zero
+	for _, l := range lines {
+		total = total.Add(l.Base)
+	}
+	if !total.IsPositive() {
+		return nil, nil
+	}
+	base, err := levelAmounts(db, ul, lines)
+	if err != nil {
+		return nil, err
+	}
+	rounding := rebateRounding(db)
+	var ts []Transaction
+	for _, c := range cs {
+		es, err := c.amounts(db, ul, lines, base)
+		if err != nil {
+			return nil, errors.New("活动" + strconv.Itoa(c.ID) + "分成比例错误: " + err.Error())
+		}
+		for i, e := range es {
+			bonus := e.Sub(base[i])
+			d := roundAmount(bonus, rounding)
+			if !d.IsPositive() {
+				continue
+			}
+			t := Transaction{}
+			t.fillTransaction(orderID, m.ID, ul[i].AncestorID, d, TranRebate)
+			t.Ratio = bonus.Div(pay).Round(ratioScale)
+			t.CampaignID = sql.NullInt64{Int64: int64(c.ID), Valid: true}
+			t.Generation = i
+			ts = append(ts, t)
+		}
+	}
+	return ts, nil
+}
+
+//campaignIDs 交易记录涉及的促销活动id
+func campaignIDs(ts []Transaction) []int {
+	var ids []int
+	seen := map[int64]bool{}
+	for _, t := range ts {
+		if t.CampaignID.Valid && !seen[t.CampaignID.Int64] {
+			seen[t.CampaignID.Int64] = true
+			ids = append(ids, int(t.CampaignID.Int64))
+		}
+	}
+	return ids
+}
+
+//GetCampaigns 促销活动列表, active 为true时仅当前有效的活动
+func GetCampaigns(db *gorm.DB, active bool) ([]CampaignOutput, error) {
+	var cs []Campaign
+	db1 := db
+	if active {
+		db1 = db1.Where("starttime<=now() and endtime>now()")
+	}
+	if db1 = db1.Order("starttime desc, id").Find(&cs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	outs := make([]CampaignOutput, len(cs))
+	for i := range cs {
+		outs[i] = CampaignOutput{cs[i].StartTime.Format(campaignTimeLayout), cs[i].EndTime.Format(campaignTimeLayout),
+			cs[i].Level.String, cs[i].Branch.String, &cs[i]}
+	}
+	return outs, nil
+}
+
+//SetCampaign 新增或修改促销活动, id为0时新增; remove 为true时删除该活动
+//	start, end 格式 2006-01-02 15:04; level, branch(消费商户) 空为不限
+//	multiplier 返利倍数; ratios 替换的各级分成比例, 百分数, 同UpdateRatios; 二者必须且只能设置一个
+//	return id, code, message
+func SetCampaign(db *gorm.DB, id int, name string, start string, end string, level string, branch string,
+	newMember bool, multiplier string, ratios []string, remove bool) (int, string, string) {
+	if remove {
+		var n int
+		if db1 := db.Model(&Transaction{}).Where("campaign_id=?", id).Count(&n); db1.Error != nil {
+			return id, ResWrongSQL, db1.Error.Error()
+		}
+		if n > 0 {
+			return id, ResInvalid, "活动已产生返利, 不能删除, 可修改结束时间提前结束"
+		}
+		db1 := db.Delete(Campaign{}, "id=?", id)
+		if db1.Error != nil {
+			return id, ResWrongSQL, db1.Error.Error()
+		}
+		if db1.RowsAffected == 0 {
+			return id, ResNotFound, "活动不存在"
+		}
+		return id, ResOK, "更新成功"
+	}
+	if len(name) == 0 {
+		return id, ResInvalid, "活动名称不能为空"
+	}
+	c := &Campaign{ID: id, Name: name, NewMember: newMember, Multiplier: zero}
+	var err error
+	if c.StartTime, err = time.ParseInLocation(campaignTimeLayout, start, time.Local); err != nil {
+		return id, ResInvalid, "无效开始时间" + start
+	}
+	if c.EndTime, err = time.ParseInLocation(campaignTimeLayout, end, time.Local); err != nil {
+		return id, ResInvalid, "无效结束时间" + end
+	}
+	if !c.EndTime.After(c.StartTime) {
+		return id, ResInvalid, "结束时间须晚于开始时间"
+	}
+	if len(level) > 0 {
+		if _, err = strconv.Atoi(level); err != nil {
+			return id, ResInvalid, "无效会员等级" + level
+		}
+		c.Level.Scan(level)
+	}
+	if len(branch) > 0 {
+		c.Branch.Scan(branch)
+	}
+	if (len(multiplier) > 0) == (len(ratios) > 0) {
+		return id, ResInvalid, "返利倍数与分成比例须设置且只能设置一个"
+	}
+	if len(multiplier) > 0 {
+		if c.Multiplier, err = decimal.NewFromString(multiplier); err != nil {
+			return id, ResInvalid, err.Error()
+		}
+		if c.Multiplier.LessThan(decimal.New(1, 0)) {
+			return id, ResInvalid, "返利倍数不能小于1"
+		}
+	} else {
+		onepercent := decimal.New(1, -2)
+		strs := make([]string, len(ratios))
+		for i, str := range ratios {
+			r, err := decimal.NewFromString(str)
+			if err != nil {
+				return id, ResInvalid, err.Error()
+			}
+			if r.IsNegative() {
+				return id, ResInvalid, "分成比例不能为负数"
+			}
+			strs[i] = onepercent.Mul(r).String()
+		}
+		c.Ratios = strings.Join(strs, ",")
+	}
+	var db1 *gorm.DB
+	if id == 0 {
+		db1 = db.Create(c)
+	} else {
+		db1 = db.Model(c).Where("id=?", id).Updates(map[string]interface{}{"name": c.Name, "starttime": c.StartTime,
+			"endtime": c.EndTime, "level": c.Level, "branch": c.Branch, "newmember": c.NewMember,
+			"multiplier": c.Multiplier, "ratios": c.Ratios})
+		if db1.Error == nil && db1.RowsAffected == 0 {
+			return id, ResNotFound, "活动不存在"
+		}
+	}
+	if db1.Error != nil {
+		return id, ResWrongSQL, db1.Error.Error()
+	}
+	return c.ID, ResOK, "更新成功"
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCampaignEligible(t *testing.T) {
+	ns := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: len(s) > 0}
+	}
+	m := &Member{ID: "m1", Level: ns("2")}
+	cases := []struct {
+		name     string
+		c        Campaign
+		merchant string
+		h        consumeHistory
+		want     bool
+	}{
+		{"no filter", Campaign{}, "", consumeHistory{}, true},
+		{"level match", Campaign{Level: ns("2")}, "", consumeHistory{}, true},
+		{"level mismatch", Campaign{Level: ns("3")}, "", consumeHistory{}, false},
+		{"branch match", Campaign{Branch: ns("shop1")}, "shop1", consumeHistory{}, true},
+		{"branch mismatch", Campaign{Branch: ns("shop1")}, "shop2", consumeHistory{}, false},
+		{"branch without merchant", Campaign{Branch: ns("shop1")}, "", consumeHistory{}, false},
+		{"first order", Campaign{NewMember: true}, "", consumeHistory{}, true},
+		{"has orders", Campaign{NewMember: true}, "", consumeHistory{Orders: 1}, false},
+		//升级前的消费只有交易记录, 没有orders记录
+		{"consume before orders table", Campaign{NewMember: true}, "", consumeHistory{Consumes: 1}, false},
+		{"history ignored when not new member only", Campaign{}, "", consumeHistory{Orders: 3, Consumes: 5}, true},
+	}
+	for _, c := range cases {
+		if got := c.c.eligible(m, c.merchant, c.h); got != c.want {
+			t.Errorf("%s: eligible = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if (&Campaign{Level: ns("2")}).eligible(&Member{ID: "m2"}, "", consumeHistory{}) {
+		t.Errorf("member without level: eligible for level campaign")
+	}
+}
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//CashoutRequested 提现状态: 已申请, 积分已冻结(扣减)
+	CashoutRequested = "requested"
+	//CashoutApproved 提现状态: 已审批, 待打款
+	CashoutApproved = "approved"
+	//CashoutRejected 提现状态: 已拒绝, 积分已退还
+	CashoutRejected = "rejected"
+	//CashoutPaid 提现状态: 已打款
+	CashoutPaid = "paid"
+	//CashoutFailed 提现状态: 打款失败, 积分已退还
+	CashoutFailed = "failed"
+
+	//CashoutApprove 提现操作: 审批通过
+	CashoutApprove = "approve"
+	//CashoutReject 提现操作: 拒绝
+	CashoutReject = "reject"
+	//CashoutPay 提现操作: 打款成功
+	CashoutPay = "pay"
+	//CashoutFail 提现操作: 打款失败
+	CashoutFail = "fail"
+
+	//cashoutPageSize 提现申请列表缺省每页记录数
+	cashoutPageSize = 50
+)
+
+var (
+	//cashoutTransitions 提现状态迁移, 当前状态 -> 操作 -> 新状态
+	cashoutTransitions = map[string]map[string]string{
+		CashoutRequested: {CashoutApprove: CashoutApproved, CashoutReject: CashoutRejected},
+		CashoutApproved:  {CashoutPay: CashoutPaid, CashoutFail: CashoutFailed},
+	}
+)
+
+//CashoutRequest 提现申请
+type CashoutRequest struct {
+	ID               string          `gorm:"column:id" json:"id"`
+	MemberID         string          `gorm:"column:member_id" json:"memberid"`
+	OrderNo          string          `gorm:"column:orderno" json:"orderno"`
+	Amount           decimal.Decimal `gorm:"column:amount" json:"amount"`
+	Fee              decimal.Decimal `gorm:"column:fee" json:"fee"`
+	Status           string          `gorm:"column:status" json:"status"`
+	TransactionID    string          `gorm:"column:transaction_id" json:"-"`
+	FeeTransactionID sql.NullString  `gorm:"column:fee_transaction_id" json:"-"`
+	Operator         string          `gorm:"column:operator" json:"operator"`
+	CreateTime       time.Time       `gorm:"column:createtime" json:"createTime"`
+	UpdTime          time.Time       `gorm:"column:updtime" json:"updTime"`
+}
+
+//CashoutLog 提现申请状态变更记录
+type CashoutLog struct {
+	ID         string         `gorm:"column:id" json:"-"`
+	RequestID  string         `gorm:"column:request_id" json:"requestid"`
+	FromStatus string         `gorm:"column:fromstatus" json:"from"`
+	ToStatus   string         `gorm:"column:tostatus" json:"to"`
+	Operator   string         `gorm:"column:operator" json:"operator"`
+	Remark     sql.NullString `gorm:"column:remark" json:"-"`
+	CreateTime time.Time      `gorm:"column:createtime" json:"time"`
+}
+
+//newCashoutRequest 填充新提现申请对象
+func newCashoutRequest(mID string, orderID string, amount decimal.Decimal, tID string) *CashoutRequest {
+	now := time.Now()
+	return &CashoutRequest{uuid.NewV4().String(), mID, orderID, amount, zero, CashoutRequested, tID, sql.NullString{}, mID, now, now}
+}
+
+//saveNew 保存新提现申请及申请记录
+func (c *CashoutRequest) saveNew(db *gorm.DB, operator string) error {
+	db.Create(c)
+	if db.NewRecord(c) {
+		return errors.New("提现申请创建失败")
+	}
+	return c.log(db, "", operator, "")
+}
+
+//log 记录状态变更
+func (c *CashoutRequest) log(db *gorm.DB, from string, operator string, remark string) error {
+	l := &CashoutLog{uuid.NewV4().String(), c.ID, from, c.Status, operator, sql.NullString{}, time.Now()}
+	if len(remark) > 0 {
+		l.Remark.Scan(remark)
+	}
+	return db.Create(l).Error
+}
+
+//CashoutAction 提现申请审批/打款操作
+//	reqID	提现申请id
+//	action	CashoutApprove/CashoutReject/CashoutPay/CashoutFail
+//	operator 操作员id
+//	拒绝或打款失败时, 退还冻结的积分及手续费到原账户记录
+//	return code, message
+func CashoutAction(db *gorm.DB, reqID string, action string, operator string, remark string) (string, string) {
+	if len(operator) == 0 {
+		return ResInvalid, "操作员不能为空"
+	}
+	tx := db.Begin() //开启事务
+	c := &CashoutRequest{}
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE").First(c, "id=?", reqID)
+	if db1.RecordNotFound() {
+		tx.Rollback()
+		return ResNotFound, "提现申请不存在"
+	}
+	if db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	to, ok := cashoutTransitions[c.Status][action]
+	if !ok {
+		tx.Rollback()
+		return ResInvalid, "状态" + c.Status + "不能执行" + action
+	}
+	if to == CashoutRejected || to == CashoutFailed {
+		if err := returnCashout(tx, c); err != nil {
+			tx.Rollback()
+			return ResWrongSQL, err.Error()
+		}
+	}
+	from := c.Status
+	c.Status = to
+	c.Operator = operator
+	c.UpdTime = time.Now()
+	if db1 = tx.Save(c); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if err := c.log(tx, from, operator, remark); err != nil {
+		tx.Rollback()
+		return ResWrongSQL, err.Error()
+	}
+	if db1 = tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return ResOK, "OK"
+}
+
+//returnCashout 退还提现冻结的积分及手续费(扣除已退款部分)
+func returnCashout(tx *gorm.DB, c *CashoutRequest) error {
+	if err := lockMember(tx, c.MemberID); err != nil {
+		return err
+	}
+	tIDs := []string{c.TransactionID}
+	if c.FeeTransactionID.Valid {
+		tIDs = append(tIDs, c.FeeTransactionID.String)
+	}
+	for _, tID := range tIDs {
+		t := &Transaction{}
+		if db1 := tx.First(t, "id=?", tID); db1.Error != nil {
+			return db1.Error
+		}
+		left, err := reversedAmount(tx, t.ID)
+		if err != nil {
+			return err
+		}
+		left = t.Amount.Add(left).Neg()
+		if err = restoreUsages(tx, t, left, true, c.OrderNo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ListCashoutRequests 按状态查询提现申请, status为空时查询全部
+func ListCashoutRequests(db *gorm.DB, status string, pageSize int, offset int) ([]CashoutRequest, error) {
+	var cs []CashoutRequest
+	if pageSize <= 0 {
+		pageSize = cashoutPageSize
+	}
+	db1 := db.Order("createtime").Limit(pageSize).Offset(offset)
+	if len(status) > 0 {
+		db1 = db1.Where("status=?", status)
+	}
+	if db1 = db1.Find(&cs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	return cs, nil
+}
+
+//CashoutHistory 提现申请状态变更记录
+func CashoutHistory(db *gorm.DB, reqID string) ([]CashoutLog, error) {
+	var ls []CashoutLog
+	if db1 := db.Order("createtime").Find(&ls, "request_id=?", reqID); db1.Error != nil {
+		return nil, db1.Error
+	}
+	return ls, nil
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//ResCashoutMin 低于单笔最低提现金额
+	ResCashoutMin = "4122"
+	//ResCashoutMax 超过单笔最高提现金额
+	ResCashoutMax = "4123"
+	//ResCashoutDaily 超过每日提现上限
+	ResCashoutDaily = "4124"
+	//ResCashoutMonthly 超过每月提现上限
+	ResCashoutMonthly = "4125"
+)
+
+//CashoutPolicy 提现规则, level为空时为全局规则, 否则覆盖对应会员等级
+//	金额分为单位, 0 不限; FeeRate 按提现金额收取的比例, 例 0.01 = 1%
+type CashoutPolicy struct {
+	ID         int             `gorm:"column:id" json:"-"`
+	Level      sql.NullString  `gorm:"column:level" json:"-"`
+	MinAmount  decimal.Decimal `gorm:"column:minamount" json:"min"`
+	MaxAmount  decimal.Decimal `gorm:"column:maxamount" json:"max"`
+	DailyCap   decimal.Decimal `gorm:"column:dailycap" json:"daily"`
+	MonthlyCap decimal.Decimal `gorm:"column:monthlycap" json:"monthly"`
+	FeeFlat    decimal.Decimal `gorm:"column:feeflat" json:"feeflat"`
+	FeeRate    decimal.Decimal `gorm:"column:feerate" json:"feerate"`
+}
+
+//CashoutPolicyOutput 提现规则输出
+type CashoutPolicyOutput struct {
+	Level string `json:"level"`
+	*CashoutPolicy
+}
+
+//getCashoutPolicy 会员适用的提现规则, 等级规则优先于全局规则; 均无时返回nil, 不做限制
+func getCashoutPolicy(db *gorm.DB, level sql.NullString) (*CashoutPolicy, error) {
+	p := &CashoutPolicy{}
+	db1 := db
+	if level.Valid && len(level.String) > 0 {
+		db1 = db1.Where("level=? or level is null", level.String)
+	} else {
+		db1 = db1.Where("level is null")
+	}
+	db1 = db1.Order("level is null").First(p)
+	if db1.RecordNotFound() {
+		return nil, nil
+	}
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	return p, nil
+}
+
+//fee 提现手续费, 固定费用+按比例费用
+func (p *CashoutPolicy) fee(amount decimal.Decimal) decimal.Decimal {
+	return p.FeeFlat.Add(amount.Mul(p.FeeRate).Round(amountScale))
+}
+
+//checkCashoutPolicy 检查提现规则, 返回手续费
+//	须在锁定会员后调用, 保证日/月累计准确
+//	return fee, code, message
+func checkCashoutPolicy(tx *gorm.DB, m *Member, amount decimal.Decimal) (decimal.Decimal, string, string) {
+	p, err := getCashoutPolicy(tx, m.Level)
+	if err != nil {
+		return zero, ResWrongSQL, err.Error()
+	}
+	if p == nil {
+		return zero, ResOK, "OK"
+	}
+	if p.MinAmount.IsPositive() && amount.LessThan(p.MinAmount) {
+		return zero, ResCashoutMin, "低于最低提现金额" + p.MinAmount.String()
+	}
+	if p.MaxAmount.IsPositive() && amount.GreaterThan(p.MaxAmount) {
+		return zero, ResCashoutMax, "超过单笔提现上限" + p.MaxAmount.String()
+	}
+	if p.DailyCap.IsPositive() {
+		used, err := cashoutSince(tx, m.ID, "current_date")
+		if err != nil {
+			return zero, ResWrongSQL, err.Error()
+		}
+		if used.Add(amount).GreaterThan(p.DailyCap) {
+			return zero, ResCashoutDaily, "超过每日提现上限" + p.DailyCap.String()
+		}
+	}
+	if p.MonthlyCap.IsPositive() {
+		used, err := cashoutSince(tx, m.ID, "date_trunc('month',current_date)")
+		if err != nil {
+			return zero, ResWrongSQL, err.Error()
+		}
+		if used.Add(amount).GreaterThan(p.MonthlyCap) {
+			return zero, ResCashoutMonthly, "超过每月提现上限" + p.MonthlyCap.String()
+		}
+	}
+	return p.fee(amount), ResOK, "OK"
+}
+
+//cashoutSince 会员自since起累计提现金额(不含手续费, 不含已拒绝/打款失败的申请)
+func cashoutSince(db *gorm.DB, mID string, since string) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("cashout_requests").Select("coalesce(sum(amount),0) as sumamount")
+	db1 = db1.Where("member_id=? and status not in (?) and createtime>="+since, mID, []string{CashoutRejected, CashoutFailed}).Scan(&a)
+	if db1.Error != nil {
+		return zero, errors.New("提现记录查询错误: " + db1.Error.Error())
+	}
+	return a.Amount, nil
+}
+
+//splitFeeUsages 从扣减的账户记录中分出手续费部分, 有效期最远的优先
+//	consumed中的Used相应减少, 返回手续费扣减明细
+func splitFeeUsages(consumed []Account, fee decimal.Decimal) []Account {
+	var fs []Account
+	for i := len(consumed) - 1; i >= 0 && fee.IsPositive(); i-- {
+		if !consumed[i].Used.IsPositive() {
+			continue
+		}
+		d := decimal.Min(consumed[i].Used, fee)
+		consumed[i].Used = consumed[i].Used.Sub(d)
+		fee = fee.Sub(d)
+		fs = append(fs, Account{ID: consumed[i].ID, Used: d})
+	}
+	return fs
+}
+
+//GetCashoutPolicies 全部提现规则
+func GetCashoutPolicies(db *gorm.DB) ([]CashoutPolicyOutput, error) {
+	var ps []CashoutPolicy
+	if db1 := db.Order("level nulls first").Find(&ps); db1.Error != nil {
+		return nil, db1.Error
+	}
+	outs := make([]CashoutPolicyOutput, len(ps))
+	for i := range ps {
+		outs[i] = CashoutPolicyOutput{ps[i].Level.String, &ps[i]}
+	}
+	return outs, nil
+}
+
+//SetCashoutPolicy 设置提现规则, level为空时设置全局规则
+//	values 依次为 min, max, daily, monthly, feeflat, feerate, 空字符串视为0
+//	return code, message
+func SetCashoutPolicy(db *gorm.DB, level string, values ...string) (string, string) {
+	ds := make([]decimal.Decimal, len(values))
+	for i, v := range values {
+		if len(v) == 0 {
+			ds[i] = zero
+			continue
+		}
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return ResInvalid, err.Error()
+		}
+		if d.IsNegative() {
+			return ResInvalid, "规则值不能为负数"
+		}
+		ds[i] = d
+	}
+	if len(ds) != 6 {
+		return ResInvalid, "参数不足"
+	}
+	tx := db.Begin() //开启事务
+	p := &CashoutPolicy{}
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE")
+	if len(level) > 0 {
+		db1 = db1.First(p, "level=?", level)
+	} else {
+		db1 = db1.First(p, "level is null")
+	}
+	if db1.Error != nil && !db1.RecordNotFound() {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if len(level) > 0 {
+		p.Level.Scan(level)
+	}
+	p.MinAmount, p.MaxAmount, p.DailyCap, p.MonthlyCap, p.FeeFlat, p.FeeRate = ds[0], ds[1], ds[2], ds[3], ds[4], ds[5]
+	if db1 = tx.Save(p); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 = tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return ResOK, "更新成功"
+}
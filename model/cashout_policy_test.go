@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSplitFeeUsages(t *testing.T) {
+	cases := []struct {
+		name      string
+		used      []string
+		fee       string
+		wantUsed  []string
+		wantFeeID []string
+		wantFee   []string
+	}{
+		{"no fee", []string{"5", "3"}, "0", []string{"5", "3"}, nil, nil},
+		{"from last lot", []string{"5", "3"}, "2", []string{"5", "1"}, []string{"a1"}, []string{"2"}},
+		{"spans lots", []string{"5", "3"}, "4", []string{"4", "0"}, []string{"a1", "a0"}, []string{"3", "1"}},
+		{"skips empty lot", []string{"5", "0"}, "1", []string{"4", "0"}, []string{"a0"}, []string{"1"}},
+		{"fee exceeds used", []string{"1", "1"}, "5", []string{"0", "0"}, []string{"a1", "a0"}, []string{"1", "1"}},
+	}
+	for _, c := range cases {
+		consumed := make([]Account, len(c.used))
+		for i, u := range c.used {
+			consumed[i] = Account{ID: "a" + string(rune('0'+i)), Used: decimal.RequireFromString(u)}
+		}
+		fs := splitFeeUsages(consumed, decimal.RequireFromString(c.fee))
+		for i, w := range c.wantUsed {
+			if !consumed[i].Used.Equal(decimal.RequireFromString(w)) {
+				t.Errorf("%s: consumed[%d].Used = %s, want %s", c.name, i, consumed[i].Used, w)
+			}
+		}
+		if len(fs) != len(c.wantFeeID) {
+			t.Fatalf("%s: %d fee usages, want %d", c.name, len(fs), len(c.wantFeeID))
+		}
+		for i := range fs {
+			if fs[i].ID != c.wantFeeID[i] || !fs[i].Used.Equal(decimal.RequireFromString(c.wantFee[i])) {
+				t.Errorf("%s: fee usage %d = %s %s, want %s %s", c.name, i, fs[i].ID, fs[i].Used, c.wantFeeID[i], c.wantFee[i])
+			}
+		}
+	}
+}
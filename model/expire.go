@@ -0,0 +1,65 @@
+package model
+
+import (
+	"time"
+
+	"github.com/e2u/goboot"
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//PointValidDays 积分有效天数配置code, <=0 永不过期
+	PointValidDays = "PointValidDays"
+
+	expireBatchSize = 200
+)
+
+//ExpireAccounts 过期积分清理: 过期账户记录余额清零, 并为每条记录产生负数交易记录
+//	返回处理的账户记录数
+func ExpireAccounts(db *gorm.DB) (int, error) {
+	total := 0
+	for {
+		n, err := expireAccountBatch(db)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < expireBatchSize {
+			return total, nil
+		}
+	}
+}
+
+//expireAccountBatch 单一事务内处理一批过期账户记录
+func expireAccountBatch(db *gorm.DB) (int, error) {
+	var as []Account
+	tx := db.Begin() //开启事务
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE").Limit(expireBatchSize).Find(&as, "expiredate<current_date and amount>0")
+	if db1.Error != nil {
+		tx.Rollback()
+		return 0, db1.Error
+	}
+	now := time.Now()
+	for i := range as {
+		t := Transaction{}
+		t.fillTransaction("", as[i].MemberID, as[i].MemberID, as[i].Amount.Neg(), TranExpire)
+		t.WalletType = as[i].WalletType
+		t.AccountID.Scan(as[i].ID) //过期的账户记录, 便于追溯
+		as[i].Amount = zero
+		as[i].UpdTime = now
+		if db1 = tx.Save(&as[i]); db1.Error != nil {
+			tx.Rollback()
+			goboot.Log.Error(db1.Error)
+			return 0, db1.Error
+		}
+		if err := t.saveNew(tx); err != nil {
+			tx.Rollback()
+			goboot.Log.Error("expire save error, rollback.")
+			return 0, err
+		}
+	}
+	if db1 = tx.Commit(); db1.Error != nil {
+		return 0, db1.Error
+	}
+	return len(as), nil
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+var (
+	//ErrIdempotencyConflict 幂等键已被不同请求内容使用
+	ErrIdempotencyConflict = errors.New("幂等键重复, 请求内容与原请求不一致")
+)
+
+//IdempotencyKey 幂等键记录, member_id+idemkey 唯一约束
+type IdempotencyKey struct {
+	MemberID    string    `gorm:"column:member_id"`
+	IdemKey     string    `gorm:"column:idemkey"`
+	Operation   string    `gorm:"column:operation"`
+	RequestHash string    `gorm:"column:requesthash"`
+	Response    string    `gorm:"column:response"`
+	CreateTime  time.Time `gorm:"column:createtime"`
+}
+
+//requestHash 请求内容摘要, 用于判断重复请求是否一致
+func requestHash(fields ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+//checkIdempotency 检查幂等键, 须在lockMember之后调用, 同一会员的请求串行检查
+//	return 原请求结果(非nil时直接重放), error
+//	请求内容不一致时返回 ErrIdempotencyConflict
+func checkIdempotency(tx *gorm.DB, mID string, key string, op string, hash string) (*ConsumeResult, error) {
+	k := &IdempotencyKey{}
+	db1 := tx.First(k, "member_id=? and idemkey=?", mID, key)
+	if db1.RecordNotFound() {
+		return nil, nil
+	}
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	if k.Operation != op || k.RequestHash != hash {
+		return nil, ErrIdempotencyConflict
+	}
+	result := &ConsumeResult{}
+	if err := json.Unmarshal([]byte(k.Response), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//saveIdempotency 保存幂等键及请求结果, 与业务数据同一事务提交; 唯一约束保证不会重复处理
+func saveIdempotency(tx *gorm.DB, mID string, key string, op string, hash string, result *ConsumeResult) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	k := &IdempotencyKey{mID, key, op, hash, string(b), time.Now()}
+	return tx.Create(k).Error
+}
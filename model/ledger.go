@@ -0,0 +1,160 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//LedgerMemberWallet 会员积分钱包(负债), 按member_id分户
+	LedgerMemberWallet = "member_wallet"
+	//LedgerRebateExpense 平台返利费用
+	LedgerRebateExpense = "rebate_expense"
+	//LedgerRedemption 积分抵用消费清算
+	LedgerRedemption = "redemption_clearing"
+	//LedgerCashout 积分提现清算
+	LedgerCashout = "cashout_clearing"
+	//LedgerExpiry 积分过期收入
+	LedgerExpiry = "expiry_income"
+	//LedgerAdjustment 调整
+	LedgerAdjustment = "adjustment"
+	//LedgerTransfer 会员转赠清算, 转出转入相抵后为0
+	LedgerTransfer = "transfer_clearing"
+	//LedgerCashoutFee 提现手续费收入
+	LedgerCashoutFee = "cashout_fee_income"
+	//LedgerRounding 平台返利舍入差额
+	LedgerRounding = "rounding_residual"
+	//LedgerRecharge 渠道充值清算
+	LedgerRecharge = "recharge_clearing"
+	//LedgerPromoExpense 促销赠送费用
+	LedgerPromoExpense = "promo_expense"
+)
+
+var (
+	//ledgerCounterparts 交易类型对应的会员钱包对方科目
+	//	冲正交易(reverse_id非空)使用原交易的对方科目
+	ledgerCounterparts = map[string]string{
+		TranRebate:        LedgerRebateExpense,
+		TranConsume:       LedgerRedemption,
+		TranCashout:       LedgerCashout,
+		TranExpire:        LedgerExpiry,
+		TranAdjust:        LedgerAdjustment,
+		TranTransferOut:   LedgerTransfer,
+		TranTransferIn:    LedgerTransfer,
+		TranCashoutFee:    LedgerCashoutFee,
+		TranRecharge:      LedgerRecharge,
+		TranRechargeBonus: LedgerPromoExpense,
+	}
+)
+
+//JournalEntry 会计分录, 每笔分录下借贷合计为0
+type JournalEntry struct {
+	ID            string         `gorm:"column:id"`
+	TransactionID sql.NullString `gorm:"column:transaction_id"`
+	TranType      string         `gorm:"column:trantype"`
+	EntryTime     time.Time      `gorm:"column:entrytime"`
+}
+
+//Posting 分录明细, amount 借方为正, 贷方为负
+type Posting struct {
+	ID       string          `gorm:"column:id"`
+	EntryID  string          `gorm:"column:entry_id"`
+	Account  string          `gorm:"column:account"`
+	MemberID sql.NullString  `gorm:"column:member_id"`
+	Amount   decimal.Decimal `gorm:"column:amount"`
+}
+
+//LedgerBalance 科目余额, 借方为正
+type LedgerBalance struct {
+	Account string          `gorm:"column:account" json:"account"`
+	Amount  decimal.Decimal `gorm:"column:amount" json:"amount"`
+}
+
+//newPosting 分录明细, mID为空时不分户
+func newPosting(account string, mID string, amount decimal.Decimal) Posting {
+	p := Posting{Account: account, Amount: amount}
+	if len(mID) > 0 {
+		p.MemberID.Scan(mID)
+	}
+	return p
+}
+
+//postTransaction 交易记录过账: 借贷会员钱包与交易类型对应的对方科目
+//	交易金额为会员钱包增加额, 正数贷记会员钱包, 负数借记会员钱包
+//	冲正交易按原交易类型过账, 原交易为trantype为空的历史数据时按金额正负推断, 见tranKind
+func postTransaction(db *gorm.DB, t *Transaction) error {
+	tranType := t.tranKind()
+	if t.ReverseID.Valid {
+		o := &Transaction{}
+		if db1 := db.Select("trantype,amount").First(o, "id=?", t.ReverseID.String); db1.Error != nil {
+			return db1.Error
+		}
+		tranType = o.tranKind() //历史数据的冲正按推断的原交易类型过账
+	}
+	counterpart, ok := ledgerCounterparts[tranType]
+	if !ok {
+		return fmt.Errorf("交易类型%s无对应会计科目", tranType)
+	}
+	ps := []Posting{
+		newPosting(LedgerMemberWallet, t.TargetID, t.Amount.Neg()),
+		newPosting(counterpart, "", t.Amount),
+	}
+	return postEntry(db, t.ID, t.TranType, ps)
+}
+
+//postEntry 保存一笔借贷平衡的分录
+//	tID 关联交易id, 可为空
+func postEntry(db *gorm.DB, tID string, tranType string, ps []Posting) error {
+	total := zero
+	for _, p := range ps {
+		total = total.Add(p.Amount)
+	}
+	if !total.Equal(zero) {
+		return errors.New("分录借贷不平衡")
+	}
+	e := &JournalEntry{ID: uuid.NewV4().String(), TranType: tranType, EntryTime: time.Now()}
+	if len(tID) > 0 {
+		e.TransactionID.Scan(tID)
+	}
+	if db1 := db.Create(e); db1.Error != nil {
+		return db1.Error
+	}
+	for i := range ps {
+		ps[i].ID = uuid.NewV4().String()
+		ps[i].EntryID = e.ID
+		if db1 := db.Create(&ps[i]); db1.Error != nil {
+			return db1.Error
+		}
+	}
+	return nil
+}
+
+//LedgerBalances 试算平衡, 各科目借方余额; 全部科目合计应为0
+func LedgerBalances(db *gorm.DB) ([]LedgerBalance, error) {
+	var lbs []LedgerBalance
+	db1 := db.Table("postings").Select("account,sum(amount) amount").Group("account").Order("account").Scan(&lbs)
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	return lbs, nil
+}
+
+//MemberLedgerBalance 会员钱包科目余额(贷方余额, 即会员积分)
+func MemberLedgerBalance(db *gorm.DB, mID string) (decimal.Decimal, error) {
+	lb := LedgerBalance{}
+	db1 := db.Table("postings").Select("account,coalesce(-sum(amount),0) amount").Where("account=? and member_id=?", LedgerMemberWallet, mID).Group("account").Scan(&lb)
+	if db1.RecordNotFound() {
+		return zero, nil
+	}
+	if db1.Error != nil {
+		return zero, db1.Error
+	}
+	return lb.Amount, nil
+}
@@ -39,6 +39,9 @@ type MemberOutput struct {
 
 const (
 	regular = "^(13[0-9]|14[57]|15[0-35-9]|18[07-9])\\d{8}$"
+
+	//treeLockKey 推荐关系修改的advisory lock key
+	treeLockKey = 20170310
 )
 
 //MapMembers2Output 转换数据库 member数组对象输出json
@@ -65,38 +68,55 @@ func (m *Member) Map2Output() *MemberOutput {
 
 //BindMemberReference 绑定推荐会员
 func BindMemberReference(db *gorm.DB, mid string, ref string) error {
+	r := NewMember()
+	if err := r.FindByID(db, ref); err != nil {
+		return err
+	}
+	tx := db.Begin() //开启事务
+	if err := lockTree(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
 	m := NewMember()
-	err := m.FindByID(db, mid)
+	err := m.FindByID(tx, mid)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	if true == m.Reference.Valid {
+		tx.Rollback()
 		return errors.New("用户已有推荐用户")
 	}
+	if err = checkRebind(tx, mid); err != nil {
+		tx.Rollback()
+		return err
+	}
 	var isAncestor bool
 	//被推荐用户不能是推荐用户的'祖先'
-	isAncestor, err = checkAncestor(db, ref, mid)
+	isAncestor, err = checkAncestor(tx, ref, mid)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	if isAncestor {
+		tx.Rollback()
 		return errors.New("不能循环推荐")
 	}
-	r := NewMember()
-	if err = r.FindByID(db, ref); err != nil {
-		return err
-	}
 	//fmt.Println(m, r)
 	m.Reference.Scan(r.ID)
-	tx := db.Begin() //开启事务
 	db1 := tx.Save(m)
 	if db1.Error != nil {
 		tx.Rollback()
 		goboot.Log.Error(db1.Error)
 		return db1.Error
 	}
-	ul, err := CreateLevels(tx, m, 1)
-	if ul == nil {
+	//会员可能已有下级(解绑后重新绑定), 一并重建各下级关系
+	ids, err := subtreeIDs(tx, m.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = rebuildSubtreeLevels(tx, m.ID, ids); err != nil {
 		tx.Rollback()
 		goboot.Log.Error(err)
 		return err
@@ -105,6 +125,12 @@ func BindMemberReference(db *gorm.DB, mid string, ref string) error {
 	return nil
 }
 
+//lockTree 锁定推荐关系(事务级advisory lock), 直至事务结束;
+//	修改members.reference_id的操作先锁定, 避免并发绑定形成循环推荐
+func lockTree(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", treeLockKey).Error
+}
+
 //ValidatePhone 校验手机号格式
 func ValidatePhone(mobileNum string) bool {
 	reg := regexp.MustCompile(regular)
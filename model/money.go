@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//UnitFen 金额单位: 分, 接口缺省单位, 同积分点数
+	UnitFen = "fen"
+	//UnitYuan 金额单位: 元, 两位小数
+	UnitYuan = "yuan"
+)
+
+var (
+	//moneyUnits 单位对应的小数位数, 1单位 = 10^n 分
+	moneyUnits = map[string]int32{
+		UnitFen:  0,
+		UnitYuan: 2,
+	}
+)
+
+//Money 金额/积分, Amount 以分为单位(库内numeric(11,2), 返利计算可能产生分以下小数)
+//	Unit 为接口输入输出单位, JSON输出为该单位下固定小数位数的字符串, 例 fen:"120" yuan:"1.20"
+type Money struct {
+	Amount decimal.Decimal
+	Unit   string
+}
+
+//NewMoney 以分为单位的金额
+func NewMoney(d decimal.Decimal) Money {
+	return Money{d, UnitFen}
+}
+
+//ValidUnit 是否有效金额单位, 空为缺省单位分
+func ValidUnit(unit string) bool {
+	if len(unit) == 0 {
+		return true
+	}
+	_, ok := moneyUnits[unit]
+	return ok
+}
+
+//ParseMoney 解析接口输入金额, unit为空时按分
+//	小数位数不得超过单位精度(分为整数, 元最多两位小数)
+func ParseMoney(s string, unit string) (Money, error) {
+	if len(unit) == 0 {
+		unit = UnitFen
+	}
+	n, ok := moneyUnits[unit]
+	if !ok {
+		return Money{}, errors.New("无效金额单位" + unit)
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Money{}, err
+	}
+	if !d.Equal(d.Round(n)) {
+		return Money{}, errors.New("金额精度超过单位" + unit)
+	}
+	return Money{d.Mul(decimal.New(1, n)), unit}, nil
+}
+
+//To 转换输出单位
+func (m Money) To(unit string) Money {
+	if len(unit) == 0 {
+		unit = UnitFen
+	}
+	return Money{m.Amount, unit}
+}
+
+//String 按输出单位格式化, 四舍五入到该单位精度
+func (m Money) String() string {
+	n := moneyUnits[m.Unit]
+	return m.Amount.Div(decimal.New(1, n)).StringFixed(n)
+}
+
+//MarshalJSON 输出单位下的字符串
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+//UnmarshalJSON 解析分为单位的字符串或数字, 用于幂等结果重放
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	if len(s) == 0 {
+		*m = NewMoney(zero)
+		return nil
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return err
+	}
+	*m = NewMoney(d)
+	return nil
+}
+
+//Scan 实现sql.Scanner, 库内金额为分
+func (m *Money) Scan(value interface{}) error {
+	m.Unit = UnitFen
+	return m.Amount.Scan(value)
+}
+
+//Value 实现driver.Valuer
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount.Value()
+}
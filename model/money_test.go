@@ -0,0 +1,101 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		s, unit string
+		want    string
+		wantErr bool
+	}{
+		{"120", "", "120", false},
+		{"120", UnitFen, "120", false},
+		{"1.2", UnitYuan, "120", false},
+		{"1.20", UnitYuan, "120", false},
+		{"-3", UnitFen, "-3", false},
+		{"1.5", UnitFen, "", true},
+		{"1.234", UnitYuan, "", true},
+		{"abc", UnitFen, "", true},
+		{"1", "jiao", "", true},
+	}
+	for _, c := range cases {
+		m, err := ParseMoney(c.s, c.unit)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q, %q) = %s, want error", c.s, c.unit, m.Amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q, %q) error: %v", c.s, c.unit, err)
+			continue
+		}
+		if !m.Amount.Equal(decimal.RequireFromString(c.want)) {
+			t.Errorf("ParseMoney(%q, %q) = %s, want %s", c.s, c.unit, m.Amount, c.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		amount, unit string
+		want         string
+	}{
+		{"120", UnitFen, "120"},
+		{"120", "", "120"},
+		{"120", UnitYuan, "1.20"},
+		{"5", UnitYuan, "0.05"},
+		{"0.5", UnitFen, "1"},
+		{"12.345", UnitFen, "12"},
+		{"-120", UnitYuan, "-1.20"},
+	}
+	for _, c := range cases {
+		m := Money{decimal.RequireFromString(c.amount), c.unit}
+		if got := m.String(); got != c.want {
+			t.Errorf("Money{%s, %q}.String() = %s, want %s", c.amount, c.unit, got, c.want)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{`"120"`, "120", false},
+		{`120`, "120", false},
+		{`"1.5"`, "1.5", false},
+		{`""`, "0", false},
+		{`"abc"`, "", true},
+	}
+	for _, c := range cases {
+		var m Money
+		err := json.Unmarshal([]byte(c.in), &m)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %s, want error", c.in, m.Amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unmarshal(%s) error: %v", c.in, err)
+			continue
+		}
+		if !m.Amount.Equal(decimal.RequireFromString(c.want)) || m.Unit != UnitFen {
+			t.Errorf("Unmarshal(%s) = %s %s, want %s fen", c.in, m.Amount, m.Unit, c.want)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	b, err := json.Marshal(NewMoney(decimal.RequireFromString("120")).To(UnitYuan))
+	if err != nil || string(b) != `"1.20"` {
+		t.Fatalf("Marshal = %s, %v, want \"1.20\"", b, err)
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+//Order 订单记录, 退款时据此计算退款比例, 累计已退款金额
+type Order struct {
+	ID         string          `gorm:"column:id"`
+	MemberID   string          `gorm:"column:member_id"`
+	OrderNo    sql.NullString  `gorm:"column:orderno"`
+	OrderType  string          `gorm:"column:ordertype"`
+	Amount     decimal.Decimal `gorm:"column:amount"`
+	PointUsed  decimal.Decimal `gorm:"column:pointused"`
+	PayAmount  decimal.Decimal `gorm:"column:payamount"`
+	Refunded   decimal.Decimal `gorm:"column:refunded"`
+	CreateTime time.Time       `gorm:"column:createtime"`
+	UpdTime    time.Time       `gorm:"column:updtime"`
+}
+
+//newOrder 填充新订单对象
+//	amount 订单总金额, point 抵用金额, pay 返利计算金额(实付)
+func newOrder(mID string, orderID string, orderType string, amount, point, pay decimal.Decimal) *Order {
+	now := time.Now()
+	o := &Order{ID: uuid.NewV4().String(), MemberID: mID, OrderType: orderType, Amount: amount,
+		PointUsed: point, PayAmount: pay, Refunded: zero, CreateTime: now, UpdTime: now}
+	if len(orderID) > 0 {
+		o.OrderNo.Scan(orderID)
+	}
+	return o
+}
+
+func (o *Order) saveNew(db *gorm.DB) error {
+	db.Create(o)
+	if db.NewRecord(o) {
+		return errors.New("订单创建失败")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//RebateCapLevel 每单每一级返利上限配置code, 分为单位, <=0 不限
+	RebateCapLevel = "RebateCapLevel"
+	//RebateCapDaily 每位上级每日返利收入上限配置code, 分为单位, <=0 不限
+	RebateCapDaily = "RebateCapDaily"
+	//RebateCapMonthly 每位上级每月返利收入上限配置code, 分为单位, <=0 不限
+	RebateCapMonthly = "RebateCapMonthly"
+	//RebateCapOrder 每单返利合计上限配置code, 分为单位, <=0 不限
+	RebateCapOrder = "RebateCapOrder"
+
+	//CapLevel 触发的上限类型: 每单每级
+	CapLevel = "level"
+	//CapDaily 触发的上限类型: 上级每日
+	CapDaily = "daily"
+	//CapMonthly 触发的上限类型: 上级每月
+	CapMonthly = "monthly"
+	//CapOrder 触发的上限类型: 每单合计
+	CapOrder = "order"
+)
+
+//RebateCapHit 触发的返利上限, Cut 为被削减的返利金额
+type RebateCapHit struct {
+	Cap        string `json:"cap"`
+	MemberID   string `json:"id"`
+	Generation int    `json:"generation"`
+	Cut        Money  `json:"cut"`
+}
+
+//rebateCaps 返利上限配置
+type rebateCaps struct {
+	level, daily, monthly, order decimal.Decimal
+}
+
+//getRebateCaps 读取返利上限配置
+func getRebateCaps(db *gorm.DB) rebateCaps {
+	capOf := func(code string) decimal.Decimal {
+		return decimal.New(int64(GetSettingInt(db, code, 0)), 0)
+	}
+	return rebateCaps{capOf(RebateCapLevel), capOf(RebateCapDaily), capOf(RebateCapMonthly), capOf(RebateCapOrder)}
+}
+
+//applyRebateCaps 按返利上限削减各级返利, 须在saveConsume之前, 锁定消费会员之后调用
+//	上级每日/每月上限按上级已得返利检查(见rebateEarned), 检查前锁定上级会员; 削减规则见rebateCaps.apply
+func applyRebateCaps(tx *gorm.DB, ts []Transaction) ([]Transaction, []RebateCapHit, error) {
+	locked := map[string]bool{}
+	earned := func(t *Transaction, cap string) (decimal.Decimal, error) {
+		if !locked[t.TargetID] && t.TargetID != t.SourceID {
+			//上级累计收入须串行检查
+			if err := lockMember(tx, t.TargetID); err != nil {
+				return zero, err
+			}
+			locked[t.TargetID] = true
+		}
+		return rebateEarned(tx, t, cap)
+	}
+	return getRebateCaps(tx).apply(ts, earned)
+}
+
+//rebateEarned 返利上级在上限cap(CapDaily/CapMonthly)统计区间内已得返利
+//	区间为返利capTime所在的日/月, capTime为空时为当前日/月
+func rebateEarned(db *gorm.DB, t *Transaction, cap string) (decimal.Decimal, error) {
+	at := t.capTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+	from, to := capPeriod(cap, at)
+	return rebateBetween(db, t.TargetID, from, to)
+}
+
+//capPeriod 上限cap的统计区间[from, to): CapMonthly 为at所在月, 其他为at所在日
+func capPeriod(cap string, at time.Time) (from time.Time, to time.Time) {
+	y, m, d := at.Date()
+	if cap == CapMonthly {
+		from = time.Date(y, m, 1, 0, 0, 0, 0, at.Location())
+		return from, from.AddDate(0, 1, 0)
+	}
+	from = time.Date(y, m, d, 0, 0, 0, 0, at.Location())
+	return from, from.AddDate(0, 0, 1)
+}
+
+//apply 按返利上限削减各级返利
+//	依次检查: 每单每级上限, 上级每日/每月上限(earned 返回上级在该上限统计区间内已得返利), 每单合计上限(由最后一笔起削减)
+//	同一上级的多笔返利(常规及促销活动)合并计算上限; 削减的返利按比例降低分成比例, 部分退款按比例扣回, 不计舍入差额(见rebateResidual)
+//	返利削减为0的交易记录被移除; 返回剩余交易记录及触发的上限
+func (caps rebateCaps) apply(ts []Transaction, earned func(t *Transaction, cap string) (decimal.Decimal, error)) ([]Transaction, []RebateCapHit, error) {
+	var hits []RebateCapHit
+	cut := func(i int, cap string, limit decimal.Decimal) {
+		if limit.IsNegative() {
+			limit = zero
+		}
+		if ts[i].Amount.GreaterThan(limit) {
+			hits = append(hits, RebateCapHit{cap, ts[i].TargetID, ts[i].Generation, NewMoney(ts[i].Amount.Sub(limit))})
+			ts[i].Ratio = ts[i].Ratio.Mul(limit).Div(ts[i].Amount).Round(ratioScale)
+			ts[i].Amount = limit
+			ts[i].capped = true
+		}
+	}
+	//granted 本单已计入的各上级返利(同一上级可有常规及促销活动多笔返利)
+	granted := map[string]decimal.Decimal{}
+	for i := range ts {
+		target := ts[i].TargetID
+		if caps.level.IsPositive() {
+			cut(i, CapLevel, caps.level.Sub(granted[target]))
+		}
+		if caps.daily.IsPositive() {
+			e, err := earned(&ts[i], CapDaily)
+			if err != nil {
+				return nil, nil, err
+			}
+			cut(i, CapDaily, caps.daily.Sub(e).Sub(granted[target]))
+		}
+		if caps.monthly.IsPositive() {
+			e, err := earned(&ts[i], CapMonthly)
+			if err != nil {
+				return nil, nil, err
+			}
+			cut(i, CapMonthly, caps.monthly.Sub(e).Sub(granted[target]))
+		}
+		granted[target] = granted[target].Add(ts[i].Amount)
+	}
+	if caps.order.IsPositive() {
+		over := totalTransactions(ts).Sub(caps.order)
+		for i := len(ts) - 1; i >= 0 && over.IsPositive(); i-- {
+			d := decimal.Min(ts[i].Amount, over)
+			over = over.Sub(d)
+			cut(i, CapOrder, ts[i].Amount.Sub(d))
+		}
+	}
+	rs := ts[:0]
+	for _, t := range ts {
+		if t.Amount.IsPositive() {
+			rs = append(rs, t)
+		}
+	}
+	return rs, hits, nil
+}
+
+//rebateBetween 会员在[from, to)内累计返利收入(扣除已扣回部分)
+func rebateBetween(db *gorm.DB, mID string, from time.Time, to time.Time) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("transactions").Select("coalesce(sum(amount),0) as sumamount")
+	db1 = db1.Where("target_id=? and trantype in (?) and transactiontime>=? and transactiontime<?", mID, []string{TranRebate, TranClawback}, from, to).Scan(&a)
+	if db1.Error != nil {
+		return zero, db1.Error
+	}
+	return a.Amount, nil
+}
+
+//totalTransactions 交易金额合计
+func totalTransactions(ts []Transaction) decimal.Decimal {
+	total := zero
+	for _, t := range ts {
+		total = total.Add(t.Amount)
+	}
+	return total
+}
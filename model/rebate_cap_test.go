@@ -0,0 +1,159 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRebateCapsApply(t *testing.T) {
+	d := decimal.RequireFromString
+	type rebate struct {
+		target, amount, ratio string
+	}
+	cases := []struct {
+		name    string
+		caps    rebateCaps
+		earned  map[string]string //上级已得返利, 每日与每月相同
+		rebates []rebate
+		want    []rebate
+		hits    []string
+	}{
+		{"no caps", rebateCaps{zero, zero, zero, zero}, nil,
+			[]rebate{{"a", "10", "0.1"}, {"b", "5", "0.05"}},
+			[]rebate{{"a", "10", "0.1"}, {"b", "5", "0.05"}}, nil},
+		{"level cap scales ratio", rebateCaps{d("4"), zero, zero, zero}, nil,
+			[]rebate{{"a", "10", "0.1"}, {"b", "2", "0.02"}},
+			[]rebate{{"a", "4", "0.04"}, {"b", "2", "0.02"}}, []string{CapLevel}},
+		{"level cap aggregates same target", rebateCaps{d("4"), zero, zero, zero}, nil,
+			[]rebate{{"a", "3", "0.03"}, {"a", "3", "0.03"}},
+			[]rebate{{"a", "3", "0.03"}, {"a", "1", "0.01"}}, []string{CapLevel}},
+		{"daily cap with earned", rebateCaps{zero, d("10"), zero, zero}, map[string]string{"a": "8"},
+			[]rebate{{"a", "5", "0.05"}, {"b", "5", "0.05"}},
+			[]rebate{{"a", "2", "0.02"}, {"b", "5", "0.05"}}, []string{CapDaily}},
+		{"monthly cap exhausted removes rebate", rebateCaps{zero, zero, d("10"), zero}, map[string]string{"a": "12"},
+			[]rebate{{"a", "5", "0.05"}, {"b", "5", "0.05"}},
+			[]rebate{{"b", "5", "0.05"}}, []string{CapMonthly}},
+		{"order cap cuts from last", rebateCaps{zero, zero, zero, d("12")}, nil,
+			[]rebate{{"a", "10", "0.1"}, {"b", "4", "0.04"}, {"c", "2", "0.02"}},
+			[]rebate{{"a", "10", "0.1"}, {"b", "2", "0.02"}}, []string{CapOrder, CapOrder}},
+	}
+	for _, c := range cases {
+		ts := make([]Transaction, len(c.rebates))
+		for i, r := range c.rebates {
+			ts[i] = Transaction{SourceID: "m", TargetID: r.target, Amount: d(r.amount), Ratio: d(r.ratio), Generation: i}
+		}
+		earned := func(t *Transaction, cap string) (decimal.Decimal, error) {
+			if e, ok := c.earned[t.TargetID]; ok {
+				return d(e), nil
+			}
+			return zero, nil
+		}
+		rs, hits, err := c.caps.apply(ts, earned)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(rs) != len(c.want) {
+			t.Fatalf("%s: %d rebates, want %d", c.name, len(rs), len(c.want))
+		}
+		for i, w := range c.want {
+			if rs[i].TargetID != w.target || !rs[i].Amount.Equal(d(w.amount)) || !rs[i].Ratio.Equal(d(w.ratio)) {
+				t.Errorf("%s: rebate %d = %s %s %s, want %s %s %s", c.name, i,
+					rs[i].TargetID, rs[i].Amount, rs[i].Ratio, w.target, w.amount, w.ratio)
+			}
+		}
+		if len(hits) != len(c.hits) {
+			t.Fatalf("%s: %d hits, want %d", c.name, len(hits), len(c.hits))
+		}
+		for i, h := range c.hits {
+			if hits[i].Cap != h {
+				t.Errorf("%s: hit %d = %s, want %s", c.name, i, hits[i].Cap, h)
+			}
+		}
+	}
+}
+
+func TestRebateResidualWithCaps(t *testing.T) {
+	d := decimal.RequireFromString
+	cases := []struct {
+		name     string
+		caps     rebateCaps
+		exacts   []string
+		rest     string
+		policy   string
+		want     []string
+		residual string
+		expense  string //返利合计+平台舍入差额, 即过账的返利费用
+	}{
+		//2.01+1.01+0.01=3.03, 合计舍入3.02
+		{"no cap platform", rebateCaps{zero, zero, zero, zero}, []string{"2.005", "1.005", "0.005"}, "0",
+			ResidualPlatform, []string{"2.01", "1.01", "0.01"}, "-0.01", "3.02"},
+		{"no cap last", rebateCaps{zero, zero, zero, zero}, []string{"2.005", "1.005", "0.005"}, "0",
+			ResidualLast, []string{"2.01", "1.00", "0.01"}, "0", "3.02"},
+		//第0级削减为2, 其余1.005+0.005=1.01, 返利1.01+0.01=1.02
+		{"level cap platform", rebateCaps{d("2"), zero, zero, zero}, []string{"2.005", "1.005", "0.005"}, "0",
+			ResidualPlatform, []string{"2", "1.01", "0.01"}, "-0.01", "3.01"},
+		{"level cap last", rebateCaps{d("2"), zero, zero, zero}, []string{"2.005", "1.005", "0.005"}, "0",
+			ResidualLast, []string{"2", "1.00", "0.01"}, "0", "3.01"},
+		//未产生交易的层级计入差额: 1.004+0.004=1.008 舍入1.01
+		{"rest", rebateCaps{zero, zero, zero, zero}, []string{"1.004"}, "0.004",
+			ResidualPlatform, []string{"1.00"}, "0.01", "1.01"},
+		{"order cap", rebateCaps{zero, zero, zero, d("3")}, []string{"2.005", "1.005", "0.005"}, "0",
+			ResidualPlatform, []string{"2.01", "0.99"}, "0", "3"},
+	}
+	earned := func(t *Transaction, cap string) (decimal.Decimal, error) {
+		return zero, nil
+	}
+	for _, c := range cases {
+		ts := make([]Transaction, len(c.exacts))
+		for i, e := range c.exacts {
+			ts[i] = Transaction{SourceID: "m", TargetID: string(rune('a' + i)), Amount: roundAmount(d(e), RoundHalfUp),
+				Ratio: d("0.1"), Generation: i, exact: d(e)}
+		}
+		rs, _, err := c.caps.apply(ts, earned)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		residual := rebateResidual(rs, d(c.rest), RoundHalfUp, c.policy)
+		if !residual.Equal(d(c.residual)) {
+			t.Errorf("%s: residual %s, want %s", c.name, residual, c.residual)
+		}
+		if len(rs) != len(c.want) {
+			t.Fatalf("%s: %d rebates, want %d", c.name, len(rs), len(c.want))
+		}
+		for i, w := range c.want {
+			if !rs[i].Amount.Equal(d(w)) {
+				t.Errorf("%s: rebate %d = %s, want %s", c.name, i, rs[i].Amount, w)
+			}
+		}
+		if expense := totalTransactions(rs).Add(residual); !expense.Equal(d(c.expense)) {
+			t.Errorf("%s: expense %s, want %s", c.name, expense, c.expense)
+		}
+	}
+}
+
+func TestCapPeriod(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	cases := []struct {
+		cap, at  string
+		from, to string
+	}{
+		{CapDaily, "2017-03-15 10:30", "2017-03-15 00:00", "2017-03-16 00:00"},
+		{CapDaily, "2017-12-31 23:59", "2017-12-31 00:00", "2018-01-01 00:00"},
+		{CapMonthly, "2017-03-15 10:30", "2017-03-01 00:00", "2017-04-01 00:00"},
+		{CapMonthly, "2017-12-01 00:00", "2017-12-01 00:00", "2018-01-01 00:00"},
+	}
+	for _, c := range cases {
+		from, to := capPeriod(c.cap, at(c.at))
+		if !from.Equal(at(c.from)) || !to.Equal(at(c.to)) {
+			t.Errorf("capPeriod(%s, %s) = %s, %s, want %s, %s", c.cap, c.at, from, to, c.from, c.to)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+//RebateRule 返利规则, 按商户(门店)及商品类别匹配分成比例
+//	merchant, category 为空表示不限; 匹配优先级: 商户+类别 > 类别 > 商户 > 会员等级分成比例/全局分成比例levelRatios
+//	Ratios 逗号分隔的各级分成比例, 例 "0.1,0.05,0.03", 超过配置层数的部分忽略
+//	Excluded 为true时, 匹配的商品不产生返利
+type RebateRule struct {
+	ID       int            `gorm:"column:id" json:"id"`
+	Merchant sql.NullString `gorm:"column:merchant" json:"-"`
+	Category sql.NullString `gorm:"column:category" json:"-"`
+	Ratios   string         `gorm:"column:ratios" json:"ratios"`
+	Excluded bool           `gorm:"column:excluded" json:"excluded"`
+}
+
+//RebateRuleOutput 返利规则输出
+type RebateRuleOutput struct {
+	Merchant string `json:"merchant"`
+	Category string `json:"category"`
+	*RebateRule
+}
+
+//ConsumeItem 消费明细行
+type ConsumeItem struct {
+	SKU      string          `json:"sku"`
+	Category string          `json:"category"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+//rebateLine 返利计算行, 基数按各级分成比例返利
+//	Tiered 为true时(未匹配返利规则), 按获得返利的上级会员等级分成比例, 见tierRatio
+type rebateLine struct {
+	Base   decimal.Decimal
+	Ratios []decimal.Decimal
+	Tiered bool
+}
+
+//parseRatios 解析规则分成比例
+func parseRatios(s string) ([]decimal.Decimal, error) {
+	var rs []decimal.Decimal
+	if len(strings.TrimSpace(s)) == 0 {
+		return rs, nil
+	}
+	for _, str := range strings.Split(s, ",") {
+		r, err := decimal.NewFromString(strings.TrimSpace(str))
+		if err != nil {
+			return nil, err
+		}
+		if r.IsNegative() {
+			return nil, errors.New("分成比例不能为负数")
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+//matchScore 规则匹配程度, -1 不匹配
+func (r *RebateRule) matchScore(merchant string, category string) int {
+	score := 0
+	if r.Category.Valid {
+		if r.Category.String != category {
+			return -1
+		}
+		score += 2
+	}
+	if r.Merchant.Valid {
+		if r.Merchant.String != merchant {
+			return -1
+		}
+		score++
+	}
+	return score
+}
+
+//ratiosFor 按规则取商品的分成比例; 无匹配规则时为全局分成比例(tiered为true), 排除的类别为nil
+func ratiosFor(rules []RebateRule, merchant string, category string) (rs []decimal.Decimal, tiered bool, err error) {
+	var best *RebateRule
+	bestScore := 0
+	for i := range rules {
+		if s := rules[i].matchScore(merchant, category); s > bestScore {
+			best, bestScore = &rules[i], s
+		}
+	}
+	if best == nil {
+		return getLevelRatios(), true, nil
+	}
+	if best.Excluded {
+		return nil, false, nil
+	}
+	rs, err = parseRatios(best.Ratios)
+	return rs, false, err
+}
+
+//getRebateLines 按商户及消费明细生成返利计算行
+//	payAmount 实付金额(返利基数), total 消费总额; 各行基数按明细金额占总额比例分摊实付金额
+//	无明细时, 整单为一行(类别为空)
+func getRebateLines(db *gorm.DB, merchant string, items []ConsumeItem, total decimal.Decimal, payAmount decimal.Decimal) ([]rebateLine, error) {
+	if len(merchant) == 0 && len(items) == 0 {
+		return []rebateLine{{payAmount, getLevelRatios(), true}}, nil
+	}
+	var rules []RebateRule
+	db1 := db
+	if len(merchant) > 0 {
+		db1 = db1.Where("merchant is null or merchant=?", merchant)
+	} else {
+		db1 = db1.Where("merchant is null")
+	}
+	if db1 = db1.Find(&rules); db1.Error != nil {
+		return nil, db1.Error
+	}
+	if len(items) == 0 {
+		items = []ConsumeItem{{Amount: total}}
+	}
+	ls := make([]rebateLine, 0, len(items))
+	for _, it := range items {
+		rs, tiered, err := ratiosFor(rules, merchant, it.Category)
+		if err != nil {
+			return nil, err
+		}
+		if len(rs) == 0 || !total.IsPositive() {
+			continue
+		}
+		ls = append(ls, rebateLine{it.Amount.Mul(payAmount).Div(total), rs, tiered})
+	}
+	return ls, nil
+}
+
+//CheckItems 校验消费明细, 明细金额合计须等于消费金额
+func CheckItems(items []ConsumeItem, total decimal.Decimal) error {
+	if len(items) == 0 {
+		return nil
+	}
+	sum := zero
+	for _, it := range items {
+		if it.Amount.IsNegative() {
+			return errors.New("明细金额不能为负数")
+		}
+		sum = sum.Add(it.Amount)
+	}
+	if !sum.Equal(total) {
+		return errors.New("明细金额合计与消费金额不一致")
+	}
+	return nil
+}
+
+//GetRebateRules 全部返利规则
+func GetRebateRules(db *gorm.DB) ([]RebateRuleOutput, error) {
+	var rs []RebateRule
+	if db1 := db.Order("merchant nulls first, category nulls first").Find(&rs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	outs := make([]RebateRuleOutput, len(rs))
+	for i := range rs {
+		outs[i] = RebateRuleOutput{rs[i].Merchant.String, rs[i].Category.String, &rs[i]}
+	}
+	return outs, nil
+}
+
+//SetRebateRule 设置返利规则, 商户+类别唯一
+//	ratios 各级分成比例, 百分数, 同UpdateRatios; excluded 为true时忽略ratios
+//	remove 为true时删除该规则
+//	return code, message
+func SetRebateRule(db *gorm.DB, merchant string, category string, ratios []string, excluded bool, remove bool) (string, string) {
+	if len(merchant) == 0 && len(category) == 0 {
+		return ResInvalid, "商户与类别不能均为空, 全局分成比例请使用setratio"
+	}
+	strs := make([]string, 0, len(ratios))
+	if !excluded && !remove {
+		if len(ratios) == 0 {
+			return ResInvalid, "分成比例不能为空"
+		}
+		onepercent := decimal.New(1, -2)
+		for _, str := range ratios {
+			r, err := decimal.NewFromString(str)
+			if err != nil {
+				return ResInvalid, err.Error()
+			}
+			if r.IsNegative() {
+				return ResInvalid, "分成比例不能为负数"
+			}
+			strs = append(strs, onepercent.Mul(r).String())
+		}
+	}
+	tx := db.Begin() //开启事务
+	r := &RebateRule{}
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE")
+	if len(merchant) > 0 {
+		db1 = db1.Where("merchant=?", merchant)
+	} else {
+		db1 = db1.Where("merchant is null")
+	}
+	if len(category) > 0 {
+		db1 = db1.Where("category=?", category)
+	} else {
+		db1 = db1.Where("category is null")
+	}
+	db1 = db1.First(r)
+	if db1.Error != nil && !db1.RecordNotFound() {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if remove {
+		if r.ID == 0 {
+			tx.Rollback()
+			return ResNotFound, "规则不存在"
+		}
+		db1 = tx.Delete(r)
+	} else {
+		if len(merchant) > 0 {
+			r.Merchant.Scan(merchant)
+		}
+		if len(category) > 0 {
+			r.Category.Scan(category)
+		}
+		r.Ratios = strings.Join(strs, ",")
+		r.Excluded = excluded
+		db1 = tx.Save(r)
+	}
+	if db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 = tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return ResOK, "更新成功"
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func decimals(ss ...string) []decimal.Decimal {
+	ds := make([]decimal.Decimal, len(ss))
+	for i, s := range ss {
+		ds[i] = decimal.RequireFromString(s)
+	}
+	return ds
+}
+
+func equalDecimals(a, b []decimal.Decimal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseRatios(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []decimal.Decimal
+		err  bool
+	}{
+		{"", nil, false},
+		{"  ", nil, false},
+		{"0.1", decimals("0.1"), false},
+		{"0.1,0.05,0", decimals("0.1", "0.05", "0"), false},
+		{" 0.1 , 0.05 ", decimals("0.1", "0.05"), false},
+		{"0.1,-0.05", nil, true},
+		{"0.1,abc", nil, true},
+		{"0.1,", nil, true},
+	}
+	for _, c := range cases {
+		got, err := parseRatios(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("parseRatios(%q) error = %v, want error %v", c.in, err, c.err)
+			continue
+		}
+		if !equalDecimals(got, c.want) {
+			t.Errorf("parseRatios(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func rule(merchant, category, ratios string, excluded bool) RebateRule {
+	return RebateRule{
+		Merchant: sql.NullString{String: merchant, Valid: len(merchant) > 0},
+		Category: sql.NullString{String: category, Valid: len(category) > 0},
+		Ratios:   ratios,
+		Excluded: excluded,
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	cases := []struct {
+		r                  RebateRule
+		merchant, category string
+		want               int
+	}{
+		{rule("", "", "", false), "m1", "food", 0},
+		{rule("m1", "", "", false), "m1", "food", 1},
+		{rule("m1", "", "", false), "m2", "food", -1},
+		{rule("", "food", "", false), "m1", "food", 2},
+		{rule("", "food", "", false), "m1", "drink", -1},
+		{rule("m1", "food", "", false), "m1", "food", 3},
+		{rule("m1", "food", "", false), "m2", "food", -1},
+		{rule("m1", "food", "", false), "m1", "drink", -1},
+		{rule("", "food", "", false), "", "", -1},
+	}
+	for _, c := range cases {
+		if got := c.r.matchScore(c.merchant, c.category); got != c.want {
+			t.Errorf("matchScore(%q, %q) for rule %q/%q = %d, want %d", c.merchant, c.category,
+				c.r.Merchant.String, c.r.Category.String, got, c.want)
+		}
+	}
+}
+
+func TestRatiosFor(t *testing.T) {
+	old := levelRatios
+	levelRatios = decimals("0.1", "0.05")
+	defer func() { levelRatios = old }()
+	rules := []RebateRule{
+		rule("m1", "", "0.2", false),
+		rule("", "food", "0.3,0.1", false),
+		rule("m1", "food", "0.4", false),
+		rule("", "tobacco", "", true),
+		rule("m2", "drink", "0.5,x", false),
+	}
+	cases := []struct {
+		merchant, category string
+		want               []decimal.Decimal
+		tiered, err        bool
+	}{
+		{"m3", "book", decimals("0.1", "0.05"), true, false},
+		{"m1", "book", decimals("0.2"), false, false},
+		{"m3", "food", decimals("0.3", "0.1"), false, false},
+		{"m1", "food", decimals("0.4"), false, false},
+		{"m1", "tobacco", nil, false, false},
+		{"m2", "drink", nil, false, true},
+	}
+	for _, c := range cases {
+		got, tiered, err := ratiosFor(rules, c.merchant, c.category)
+		if (err != nil) != c.err {
+			t.Errorf("ratiosFor(%q, %q) error = %v, want error %v", c.merchant, c.category, err, c.err)
+			continue
+		}
+		if !equalDecimals(got, c.want) || tiered != c.tiered {
+			t.Errorf("ratiosFor(%q, %q) = %v, %v, want %v, %v", c.merchant, c.category, got, tiered, c.want, c.tiered)
+		}
+	}
+}
+
+func TestCheckItems(t *testing.T) {
+	item := func(amount string) ConsumeItem {
+		return ConsumeItem{Amount: decimal.RequireFromString(amount)}
+	}
+	cases := []struct {
+		items []ConsumeItem
+		total string
+		err   bool
+	}{
+		{nil, "100", false},
+		{[]ConsumeItem{item("100")}, "100", false},
+		{[]ConsumeItem{item("60"), item("40.00")}, "100", false},
+		{[]ConsumeItem{item("60"), item("0")}, "60", false},
+		{[]ConsumeItem{item("60"), item("30")}, "100", true},
+		{[]ConsumeItem{item("120"), item("-20")}, "100", true},
+	}
+	for i, c := range cases {
+		if err := CheckItems(c.items, decimal.RequireFromString(c.total)); (err != nil) != c.err {
+			t.Errorf("case %d: CheckItems(total %s) error = %v, want error %v", i, c.total, err, c.err)
+		}
+	}
+}
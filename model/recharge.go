@@ -0,0 +1,243 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//TranRecharge 交易类型: 渠道充值, 计入储值钱包
+	TranRecharge = "recharge"
+	//TranRechargeBonus 交易类型: 充值赠送, 计入促销钱包
+	TranRechargeBonus = "recharge_bonus"
+
+	//RechargeRebate 充值返利开关配置code, 1 按消费相同的上级关系及分成比例返利(不含充值会员自己), 0 不返利
+	RechargeRebate = "RechargeRebate"
+)
+
+//Recharge 渠道充值记录, 渠道+外部流水号唯一, 重复请求返回原结果
+type Recharge struct {
+	ID            string          `gorm:"column:id"`
+	MemberID      string          `gorm:"column:member_id"`
+	Channel       string          `gorm:"column:channel"`
+	ExternalRef   string          `gorm:"column:externalref"`
+	Amount        decimal.Decimal `gorm:"column:amount"`
+	Bonus         decimal.Decimal `gorm:"column:bonus"`
+	Rebate        decimal.Decimal `gorm:"column:rebate"`
+	TransactionID string          `gorm:"column:transaction_id"`
+	CreateTime    time.Time       `gorm:"column:createtime"`
+}
+
+//RechargeTier 充值赠送档位, 充值金额不低于MinAmount的最高档位赠送Bonus, 分为单位
+type RechargeTier struct {
+	MinAmount decimal.Decimal `gorm:"column:minamount" json:"min"`
+	Bonus     decimal.Decimal `gorm:"column:bonus" json:"bonus"`
+}
+
+//RechargeResult 充值接口返回结果
+type RechargeResult struct {
+	//RechargeID 充值记录id
+	RechargeID string
+	//Amount 充值金额(储值)
+	Amount Money
+	//Bonus 赠送金额(促销积分)
+	Bonus Money
+	//GainPoints 上级返利合计
+	GainPoints Money
+	//CapsHit 触发的返利上限
+	CapsHit []RebateCapHit
+}
+
+//result 充值记录对应的返回结果, 用于重复请求
+func (r *Recharge) result() *RechargeResult {
+	return &RechargeResult{RechargeID: r.ID, Amount: NewMoney(r.Amount), Bonus: NewMoney(r.Bonus), GainPoints: NewMoney(r.Rebate)}
+}
+
+//rechargeBonus 充值金额对应的赠送金额
+func rechargeBonus(db *gorm.DB, amount decimal.Decimal) (decimal.Decimal, error) {
+	b := &RechargeTier{}
+	db1 := db.Where("minamount<=?", amount).Order("minamount desc").First(b)
+	if db1.RecordNotFound() {
+		return zero, nil
+	}
+	if db1.Error != nil {
+		return zero, db1.Error
+	}
+	return b.Bonus, nil
+}
+
+//RechargeStored 渠道充值, 计入储值钱包, 按赠送档位另行产生促销积分
+//	m member
+//	amountStr	金额字符串形式, 分为单位
+//	channel, externalRef	支付渠道及渠道流水号, 唯一; 重复请求返回原结果, 请求内容不一致时返回ResConflict
+//	储值不过期, 赠送积分有效期按PointValidDays; RechargeRebate开启时按充值金额为上级返利
+//	return result, code, message
+func RechargeStored(db *gorm.DB, m *Member, amountStr string, channel string, externalRef string) (*RechargeResult, string, string) {
+	if len(channel) == 0 || len(externalRef) == 0 {
+		return nil, ResInvalid, "渠道及渠道流水号不能为空"
+	}
+	amount, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return nil, ResInvalid, err.Error()
+	}
+	if !amount.IsPositive() {
+		return nil, ResInvalid, "充值金额必须大于0"
+	}
+	tx := db.Begin() //开启事务
+	result, code, msg := rechargeStored(tx, m, amount, channel, externalRef)
+	if code != ResOK {
+		tx.Rollback()
+		return nil, code, msg
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	return result, ResOK, "OK"
+}
+
+func rechargeStored(tx *gorm.DB, m *Member, amount decimal.Decimal, channel string, externalRef string) (*RechargeResult, string, string) {
+	if err := lockMember(tx, m.ID); err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	old := &Recharge{}
+	db1 := tx.First(old, "channel=? and externalref=?", channel, externalRef)
+	if db1.Error == nil {
+		if old.MemberID != m.ID || !old.Amount.Equal(amount) {
+			return nil, ResConflict, ErrIdempotencyConflict.Error()
+		}
+		return old.result(), ResOK, "OK"
+	}
+	if !db1.RecordNotFound() {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	//订单号: 渠道:流水号, 关联充值,赠送及返利交易
+	orderID := channel + ":" + externalRef
+	validcode, err := vaildOrderID(tx, m.ID, orderID)
+	if validcode == 1 {
+		return nil, ResDup, "order No. exist"
+	}
+	if validcode > 0 {
+		return nil, ResFail, err.Error()
+	}
+
+	t := Transaction{}
+	t.fillTransaction(orderID, m.ID, m.ID, amount, TranRecharge)
+	t.WalletType = WalletStored
+	ts := []Transaction{t}
+	bonus, err := rechargeBonus(tx, amount)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	if bonus.IsPositive() {
+		b := Transaction{}
+		b.fillTransaction(orderID, m.ID, m.ID, bonus, TranRechargeBonus)
+		b.WalletType = WalletPromo
+		ts = append(ts, b)
+	}
+	rebates, hits, residual, err := rechargeRebates(tx, m, amount, orderID)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	ts = append(ts, rebates...)
+
+	accounts := getAccountPoints(tx, ts)
+	accounts[0].ExpireDate = nil //储值不过期
+	o := newOrder(m.ID, orderID, TranRecharge, amount, zero, amount)
+	if err = saveConsume(tx, o, ts, accounts, nil, nil); err != nil {
+		return nil, ResWrongSQL, "保存错误"
+	}
+	if len(rebates) > 0 {
+		if err = postRebateResidual(tx, ts[len(ts)-1].ID, residual); err != nil {
+			return nil, ResWrongSQL, err.Error()
+		}
+	}
+	r := &Recharge{ID: uuid.NewV4().String(), MemberID: m.ID, Channel: channel, ExternalRef: externalRef, Amount: amount,
+		Bonus: bonus, Rebate: totalTransactions(rebates), TransactionID: t.ID, CreateTime: time.Now()}
+	if db1 = tx.Create(r); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	result := r.result()
+	result.CapsHit = hits
+	return result, ResOK, "OK"
+}
+
+//rechargeRebates 充值返利, RechargeRebate开启时按消费相同的上级关系及分成比例产生返利, 不含充值会员自己(第0代)
+//	同样受返利上限约束; 舍入差额同Consume, 返回平台承担的差额residual待过账(见rebateResidual)
+func rechargeRebates(tx *gorm.DB, m *Member, amount decimal.Decimal, orderID string) (ts []Transaction, hits []RebateCapHit, residual decimal.Decimal, err error) {
+	if GetSettingInt(tx, RechargeRebate, 0) != 1 {
+		return nil, nil, zero, nil
+	}
+	ul, err := getLevelsByMember(tx, m.ID)
+	if err != nil {
+		return nil, nil, zero, err
+	}
+	if len(ul) == 0 {
+		return nil, nil, zero, errors.New("用户关系错误")
+	}
+	ts, rest, err := createTransactionsByLevels(tx, ul, []rebateLine{{amount, getLevelRatios(), true}}, amount, 1, orderID)
+	if err != nil {
+		return nil, nil, zero, err
+	}
+	if ts, hits, err = applyRebateCaps(tx, ts); err != nil {
+		return nil, nil, zero, err
+	}
+	residual = rebateResidual(ts, rest, rebateRounding(tx), GetSettingString(tx, RebateResidual, ResidualLast))
+	return ts, hits, residual, nil
+}
+
+//GetRechargeTiers 全部充值赠送档位, 按充值金额
+func GetRechargeTiers(db *gorm.DB) ([]RechargeTier, error) {
+	var bs []RechargeTier
+	if db1 := db.Order("minamount").Find(&bs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	return bs, nil
+}
+
+//SetRechargeTier 设置充值赠送档位, 充值金额唯一; remove 为true时删除该档位
+//	minAmount, bonus 分为单位
+//	return code, message
+func SetRechargeTier(db *gorm.DB, minAmount string, bonus string, remove bool) (string, string) {
+	min, err := decimal.NewFromString(minAmount)
+	if err != nil {
+		return ResInvalid, err.Error()
+	}
+	if !min.IsPositive() {
+		return ResInvalid, "充值金额必须大于0"
+	}
+	if remove {
+		db1 := db.Delete(RechargeTier{}, "minamount=?", min)
+		if db1.Error != nil {
+			return ResWrongSQL, db1.Error.Error()
+		}
+		if db1.RowsAffected == 0 {
+			return ResNotFound, "档位不存在"
+		}
+		return ResOK, "更新成功"
+	}
+	b, err := decimal.NewFromString(bonus)
+	if err != nil {
+		return ResInvalid, err.Error()
+	}
+	if !b.IsPositive() {
+		return ResInvalid, "赠送金额必须大于0"
+	}
+	tx := db.Begin() //开启事务
+	if db1 := tx.Delete(RechargeTier{}, "minamount=?", min); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 := tx.Create(&RechargeTier{min, b}); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return ResOK, "更新成功"
+}
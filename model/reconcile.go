@@ -0,0 +1,179 @@
+package model
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//DiscrepancyBalance 账户记录余额与交易记录/会员钱包科目不一致
+	DiscrepancyBalance = "balance"
+	//DiscrepancyLevels user_levels与members.reference_id推导的关系不一致
+	DiscrepancyLevels = "levels"
+	//DiscrepancyLedger 全部分录借贷不平衡
+	DiscrepancyLedger = "ledger"
+)
+
+//Discrepancy 对账差异
+type Discrepancy struct {
+	MemberID string `json:"id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	//Accounts 账户记录合计; levels时为期望关系数
+	Accounts string `json:"accounts"`
+	//Transactions 交易记录合计; levels时为实际关系数
+	Transactions string `json:"transactions"`
+	//Ledger 会员钱包科目余额
+	Ledger string `json:"ledger"`
+	Fixed  bool   `json:"fixed"`
+}
+
+//balanceRow 会员余额三方对比
+type balanceRow struct {
+	MemberID     string          `gorm:"column:member_id"`
+	Name         string          `gorm:"column:name"`
+	Accounts     decimal.Decimal `gorm:"column:accounts"`
+	Transactions decimal.Decimal `gorm:"column:transactions"`
+	Ledger       decimal.Decimal `gorm:"column:ledger"`
+}
+
+//levelRow 会员关系数对比
+type levelRow struct {
+	MemberID string `gorm:"column:member_id"`
+	Expected int    `gorm:"column:expected"`
+	Actual   int    `gorm:"column:actual"`
+}
+
+const (
+	reconcileBalanceSQL = `select m.id member_id,coalesce(m.name,'') as name,coalesce(a.total,0) accounts,coalesce(t.total,0) transactions,coalesce(p.total,0) ledger from members m
+ left join (select member_id,sum(amount) total from accounts group by member_id) a on a.member_id=m.id
+ left join (select target_id,sum(amount) total from transactions group by target_id) t on t.target_id=m.id
+ left join (select member_id,-sum(amount) total from postings where account=? group by member_id) p on p.member_id=m.id
+ where coalesce(a.total,0)<>coalesce(t.total,0) or coalesce(a.total,0)<>coalesce(p.total,0) order by m.id`
+
+	//expectedLevelsSQL 由members.reference_id推导的关系(含自己, 第0代), 代数小于返利层数
+	expectedLevelsSQL = `with recursive anc(son,ancestor,gen) as (
+ select id,id,0 from members
+ union all
+ select anc.son,m.reference_id,anc.gen+1 from anc join members m on m.id=anc.ancestor where m.reference_id is not null and anc.gen+1<?)`
+
+	reconcileLevelsSQL = expectedLevelsSQL + `
+ select coalesce(e.son,u.sonnode_id) member_id,count(e.son) expected,count(u.sonnode_id) actual
+ from anc e full outer join user_levels u on u.sonnode_id=e.son and u.ancestornode_id=e.ancestor and u.generations=e.gen
+ group by coalesce(e.son,u.sonnode_id) having count(*)<>count(e.son) or count(*)<>count(u.sonnode_id)`
+)
+
+//Reconcile 对账: 逐会员比较账户记录合计, 交易记录合计, 会员钱包科目余额, 及user_levels关系
+//	fix=true 时写入调整: 重建不一致的user_levels; 以账户记录为准, 补记调整交易及分录
+func Reconcile(db *gorm.DB, fix bool) ([]Discrepancy, error) {
+	ds := make([]Discrepancy, 0)
+
+	var lrs []levelRow
+	db1 := db.Raw(reconcileLevelsSQL, len(getLevelRatios())).Scan(&lrs)
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	for _, lr := range lrs {
+		d := Discrepancy{MemberID: lr.MemberID, Kind: DiscrepancyLevels, Accounts: strconv.Itoa(lr.Expected), Transactions: strconv.Itoa(lr.Actual)}
+		if fix {
+			if err := rebuildLevels(db, lr.MemberID); err != nil {
+				return ds, err
+			}
+			d.Fixed = true
+		}
+		ds = append(ds, d)
+	}
+
+	var brs []balanceRow
+	db1 = db.Raw(reconcileBalanceSQL, LedgerMemberWallet).Scan(&brs)
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	for _, br := range brs {
+		d := Discrepancy{br.MemberID, br.Name, DiscrepancyBalance, br.Accounts.String(), br.Transactions.String(), br.Ledger.String(), false}
+		if fix {
+			if err := adjustBalance(db, &br); err != nil {
+				return ds, err
+			}
+			d.Fixed = true
+		}
+		ds = append(ds, d)
+	}
+
+	lb := LedgerBalance{}
+	db1 = db.Table("postings").Select("'' as account,coalesce(sum(amount),0) amount").Scan(&lb)
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	if !lb.Amount.Equal(zero) {
+		ds = append(ds, Discrepancy{Kind: DiscrepancyLedger, Ledger: lb.Amount.String()})
+	}
+	return ds, nil
+}
+
+//rebuildLevels 按members.reference_id重建会员的user_levels记录
+func rebuildLevels(db *gorm.DB, mID string) error {
+	tx := db.Begin() //开启事务
+	db1 := tx.Delete(UserLevel{}, "sonnode_id=?", mID)
+	if db1.Error != nil {
+		tx.Rollback()
+		return db1.Error
+	}
+	var es []UserLevel
+	db1 = tx.Raw(expectedLevelsSQL+" select son sonnode_id,ancestor ancestornode_id,gen generations from anc where son=? order by gen", len(getLevelRatios()), mID).Scan(&es)
+	if db1.Error != nil {
+		tx.Rollback()
+		return db1.Error
+	}
+	for _, e := range es {
+		u := &UserLevel{}
+		if u.AddNewUserLevel(tx, e.SonID, e.AncestorID, e.Generations) {
+			tx.Rollback()
+			return errors.New("user level 创建失败")
+		}
+	}
+	return tx.Commit().Error
+}
+
+//adjustBalance 以账户记录为准调整: 补记调整交易(同时过账), 再补记未过账的会员钱包分录
+func adjustBalance(db *gorm.DB, br *balanceRow) error {
+	tx := db.Begin() //开启事务
+	ledger := br.Ledger
+	if diff := br.Accounts.Sub(br.Transactions); !diff.Equal(zero) {
+		t := &Transaction{}
+		t.fillTransaction("", br.MemberID, br.MemberID, diff, TranAdjust)
+		if err := t.saveNew(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		ledger = ledger.Add(diff)
+	}
+	if diff := br.Accounts.Sub(ledger); !diff.Equal(zero) {
+		ps := []Posting{
+			newPosting(LedgerMemberWallet, br.MemberID, diff.Neg()),
+			newPosting(LedgerAdjustment, "", diff),
+		}
+		if err := postEntry(tx, "", TranAdjust, ps); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+//WriteDiscrepanciesCSV 对账差异输出csv
+func WriteDiscrepanciesCSV(w io.Writer, ds []Discrepancy) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "name", "kind", "accounts", "transactions", "ledger", "fixed"})
+	for _, d := range ds {
+		cw.Write([]string{d.MemberID, d.Name, d.Kind, d.Accounts, d.Transactions, d.Ledger, strconv.FormatBool(d.Fixed)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,318 @@
+package model
+
+import (
+	"time"
+
+	"github.com/e2u/goboot"
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+//RefundResult 退款接口返回结果
+type RefundResult struct {
+	//PointRestored 退还抵用金额
+	PointRestored Money
+	//ClawbackPoints 扣回返利总金额
+	ClawbackPoints Money
+	//Refunded 订单累计退款金额, 历史订单为0
+	Refunded Money
+	//PendingCancelled 扣回返利中取消的待到账金额
+	PendingCancelled Money
+}
+
+//usageBalance 账户记录未恢复的扣减金额
+type usageBalance struct {
+	AccountID string          `gorm:"column:account_id"`
+	Amount    decimal.Decimal `gorm:"column:amount"`
+}
+
+//Refund 订单退款冲正, 单一事务内完成(锁定会员, 再按id顺序锁定各级上级会员记录):
+//	扣回各级返利(已消费部分计为欠款), 恢复订单抵用的原账户记录, 产生关联原订单号的冲正交易记录
+// 	m member
+//  order:订单id
+//  amountStr	退款金额, 分为单位; 空时退还订单全部剩余金额
+//	部分退款按退款金额占订单金额比例, 以原返利比例扣回各级返利, 按比例退还抵用积分, 返利舍入差额同Consume处理;
+//	最后一次退款(累计退款等于订单金额)扣回全部剩余返利, 舍入差额在此消除, 见refundRebates
+//	return result, code, message
+func Refund(db *gorm.DB, m *Member, orderID string, amountStr string) (*RefundResult, string, string) {
+	if len(orderID) == 0 {
+		return nil, ResInvalid, "订单号不能为空"
+	}
+	var refund decimal.Decimal
+	partial := len(amountStr) > 0
+	if partial {
+		var err error
+		refund, err = decimal.NewFromString(amountStr)
+		if err != nil {
+			return nil, ResInvalid, err.Error()
+		}
+		if !refund.IsPositive() {
+			return nil, ResInvalid, "退款金额必须大于0"
+		}
+	}
+	tx := db.Begin() //开启事务
+	if err := lockMember(tx, m.ID); err != nil {
+		tx.Rollback()
+		return nil, ResWrongSQL, err.Error()
+	}
+	o := &Order{}
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE").First(o, "member_id=? and orderno=?", m.ID, orderID)
+	legacy := db1.RecordNotFound() //无订单记录的历史订单, 仅支持全额退款
+	if db1.Error != nil && !legacy {
+		tx.Rollback()
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	if !legacy && o.OrderType == TranCashout {
+		tx.Rollback()
+		return nil, ResInvalid, "提现订单通过提现审批拒绝或打款失败退还"
+	}
+	if !legacy && o.OrderType == TranRecharge {
+		tx.Rollback()
+		return nil, ResInvalid, "充值订单不支持退款"
+	}
+	var ts []Transaction
+	db1 = tx.Find(&ts, "source_id=? and order_id=? and reverse_id is null", m.ID, orderID)
+	if db1.Error != nil {
+		tx.Rollback()
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	if len(ts) == 0 {
+		tx.Rollback()
+		return nil, ResNotFound, "订单不存在"
+	}
+	final := true
+	var restoreBase, rebateBase decimal.Decimal
+	if legacy {
+		if partial {
+			tx.Rollback()
+			return nil, ResInvalid, "历史订单仅支持全额退款"
+		}
+	} else {
+		remain := o.Amount.Sub(o.Refunded)
+		if !remain.IsPositive() {
+			tx.Rollback()
+			return nil, ResDup, "订单已全额退款"
+		}
+		if !partial {
+			refund = remain
+		}
+		if refund.GreaterThan(remain) {
+			tx.Rollback()
+			return nil, ResInvalid, "退款金额超过订单可退金额" + remain.String()
+		}
+		final = refund.Equal(remain)
+		restoreBase = o.PointUsed.Mul(refund).Div(o.Amount).Round(amountScale)
+		rebateBase = o.PayAmount.Mul(refund).Div(o.Amount)
+		o.Refunded = o.Refunded.Add(refund)
+		o.UpdTime = time.Now()
+		if db1 = tx.Save(o); db1.Error != nil {
+			tx.Rollback()
+			return nil, ResWrongSQL, db1.Error.Error()
+		}
+	}
+	restored := zero
+	clawback, cancelled, err := refundRebates(tx, m.ID, orderID, ts, rebateBase, final)
+	if err != nil {
+		tx.Rollback()
+		goboot.Log.Error(err)
+		return nil, ResWrongSQL, err.Error()
+	}
+	for i := range ts {
+		var left, d decimal.Decimal
+		switch ts[i].tranKind() { //历史数据按金额正负推断交易类型
+		case TranConsume, TranCashout:
+			if left, err = reversedAmount(tx, ts[i].ID); err == nil {
+				left = ts[i].Amount.Add(left).Neg()
+				d = left
+				if !final {
+					d = decimal.Min(restoreBase, left)
+				}
+				err = restoreUsages(tx, &ts[i], d, final, orderID)
+				restored = restored.Add(d)
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			goboot.Log.Error(err)
+			return nil, ResWrongSQL, err.Error()
+		}
+	}
+	if legacy && !restored.IsPositive() && !clawback.IsPositive() {
+		tx.Rollback()
+		return nil, ResDup, "订单已退款"
+	}
+	if db1 = tx.Commit(); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	result := &RefundResult{NewMoney(restored), NewMoney(clawback), NewMoney(zero), NewMoney(cancelled)} //历史订单无订单金额记录
+	if !legacy {
+		result.Refunded = NewMoney(o.Refunded)
+	}
+	return result, ResOK, "OK"
+}
+
+//refundRebates 扣回订单的各级返利, 返回扣回金额及其中取消的待到账金额
+//	部分退款按clawbackAmounts计算各笔扣回金额, 平台承担的舍入差额按与Consume相反方向过账;
+//	最后一次退款扣回全部剩余返利, 并冲回该订单剩余的平台舍入差额, 多次部分退款与全额退款合计一致
+func refundRebates(tx *gorm.DB, mID string, orderID string, ts []Transaction, base decimal.Decimal, final bool) (clawback decimal.Decimal, cancelled decimal.Decimal, err error) {
+	clawback, cancelled = zero, zero
+	var rebates []Transaction
+	var targets []string
+	for i := range ts {
+		if ts[i].tranKind() == TranRebate { //历史数据按金额正负推断交易类型
+			rebates = append(rebates, ts[i])
+			targets = append(targets, ts[i].TargetID)
+		}
+	}
+	if len(rebates) == 0 {
+		return zero, zero, nil
+	}
+	//扣回返利需扣减上级账户记录, 同样锁定上级会员
+	if err = lockMembers(tx, targets); err != nil {
+		return zero, zero, err
+	}
+	lefts := make([]decimal.Decimal, len(rebates))
+	for i := range rebates {
+		left, err := reversedAmount(tx, rebates[i].ID)
+		if err != nil {
+			return zero, zero, err
+		}
+		lefts[i] = rebates[i].Amount.Add(left)
+	}
+	ds, residual := lefts, zero
+	if !final {
+		ds, residual = clawbackAmounts(base, rebates, lefts, rebateRounding(tx), GetSettingString(tx, RebateResidual, ResidualLast))
+		residual = residual.Neg()
+	} else if residual, err = orderResidual(tx, mID, orderID); err != nil {
+		return zero, zero, err
+	}
+	for i := range rebates {
+		c, err := clawbackRebate(tx, &rebates[i], ds[i], orderID)
+		if err != nil {
+			return zero, zero, err
+		}
+		clawback = clawback.Add(ds[i])
+		cancelled = cancelled.Add(c)
+	}
+	return clawback, cancelled, postRebateResidual(tx, rebates[len(rebates)-1].ID, residual)
+}
+
+//clawbackRebate 扣回一笔返利中的amount, 返回其中取消的待到账金额
+//	优先扣减该返利产生的账户记录, 到账期限内(待到账)时即取消该返利, 不产生欠款;
+//	不足部分按消费顺序扣减其他有效账户记录, 仍不足时, 返利账户记录记为负数(欠款), 后续消费时冲抵
+func clawbackRebate(tx *gorm.DB, rebate *Transaction, amount decimal.Decimal, orderID string) (decimal.Decimal, error) {
+	if !amount.IsPositive() {
+		return zero, nil
+	}
+	now := time.Now()
+	t := &Transaction{}
+	t.fillTransaction(orderID, rebate.SourceID, rebate.TargetID, amount.Neg(), TranClawback)
+	t.ReverseID.Scan(rebate.ID)
+	t.CampaignID = rebate.CampaignID
+	if err := t.saveNew(tx); err != nil {
+		return zero, err
+	}
+	remind, cancelled := amount, zero
+	lot := &Account{}
+	isNew := false
+	db1 := tx.Set("gorm:query_option", "FOR UPDATE").First(lot, "transaction_id=?", rebate.ID)
+	if db1.RecordNotFound() {
+		//无对应账户记录的历史数据, 新建欠款记录承接
+		isNew = true
+		lot = &Account{ID: uuid.NewV4().String(), MemberID: rebate.TargetID, StartDate: now, GetDate: now, GetAmount: zero, WalletType: WalletRebate}
+		lot.TransactionID.Scan(t.ID)
+	} else if db1.Error != nil {
+		return zero, db1.Error
+	} else if lot.Amount.IsPositive() {
+		lot.Used = decimal.Min(lot.Amount, remind)
+		lot.Amount = lot.Amount.Sub(lot.Used)
+		lot.UpdTime = now
+		remind = remind.Sub(lot.Used)
+		if lot.StartDate.After(now) {
+			cancelled = lot.Used
+		}
+		if db1 = tx.Save(lot); db1.Error != nil {
+			return zero, db1.Error
+		}
+	}
+	if remind.IsPositive() {
+		rest, as, err := drawAccounts(tx, rebate.TargetID, remind, walletsFor(TranClawback))
+		if err != nil {
+			return zero, err
+		}
+		for i := range as {
+			if db1 = tx.Save(&as[i]); db1.Error != nil {
+				return zero, db1.Error
+			}
+			if err = saveUsage(tx, t.ID, &as[i]); err != nil {
+				return zero, err
+			}
+		}
+		if rest.IsPositive() {
+			lot.Amount = lot.Amount.Sub(rest)
+			lot.Used = lot.Used.Add(rest)
+			lot.UpdTime = now
+			if isNew {
+				err = lot.saveNew(tx)
+			} else {
+				err = tx.Save(lot).Error
+			}
+			if err != nil {
+				return zero, err
+			}
+		}
+	}
+	return cancelled, saveUsage(tx, t.ID, lot)
+}
+
+//restoreUsages 恢复一笔抵用/提现交易扣减的账户记录中的amount, 产生退还交易记录
+//	按有效期由远及近恢复, final=true时恢复全部未恢复明细(含冲抵的欠款)
+func restoreUsages(tx *gorm.DB, consume *Transaction, amount decimal.Decimal, final bool, orderID string) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+	var ubs []usageBalance
+	db1 := tx.Table("account_usages u").Joins("JOIN transactions t ON t.id=u.transaction_id").Joins("JOIN accounts a ON a.id=u.account_id")
+	db1 = db1.Select("u.account_id account_id,sum(u.amount) amount").Where("t.id=? or t.reverse_id=?", consume.ID, consume.ID)
+	db1 = db1.Group("u.account_id,a.expiredate").Order("a.expiredate desc nulls first").Scan(&ubs)
+	if db1.Error != nil {
+		return db1.Error
+	}
+	t := &Transaction{}
+	t.fillTransaction(orderID, consume.SourceID, consume.TargetID, amount, TranRefund)
+	t.WalletType = consume.WalletType
+	t.ReverseID.Scan(consume.ID)
+	if err := t.saveNew(tx); err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(ubs) == 0 {
+		//无扣减明细的历史数据, 以新账户记录退还
+		a := getAccountPoints(tx, []Transaction{*t})[0]
+		return a.saveNew(tx)
+	}
+	remind := amount
+	for _, ub := range ubs {
+		d := ub.Amount
+		if !final {
+			if !d.IsPositive() || !remind.IsPositive() {
+				continue
+			}
+			d = decimal.Min(d, remind)
+		} else if d.Equal(zero) {
+			continue
+		}
+		remind = remind.Sub(d)
+		db1 = tx.Model(&Account{}).Where("id=?", ub.AccountID).Updates(map[string]interface{}{"amount": gorm.Expr("amount+?", d), "updtime": now})
+		if db1.Error != nil {
+			return db1.Error
+		}
+		a := &Account{ID: ub.AccountID, Used: d.Neg(), UpdTime: now}
+		if err := saveUsage(tx, t.ID, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//RefChangeReparent 推荐关系变更类型: 管理员调整推荐人, 下级一并移动
+	RefChangeReparent = "reparent"
+	//RefChangeUnbind 推荐关系变更类型: 解除推荐关系, 会员及其下级成为独立的树
+	RefChangeUnbind = "unbind"
+
+	//RebindCoolingDays 解绑后重新绑定推荐人的冷静期天数配置code, <=0 不限制
+	RebindCoolingDays = "RebindCoolingDays"
+)
+
+//ReferenceChange 推荐关系变更记录
+//	Descendants 一并移动的下级人数(不含本人); Recomputed 是否转移历史返利
+//	Clawback 自原上级扣回的返利, Reissued 转给新上级的返利, 分为单位
+//	RebindAfter 解绑时的冷静期截止时间, 此前不能重新绑定推荐人, 为空不限制
+type ReferenceChange struct {
+	ID           int             `gorm:"column:id" json:"changeid"`
+	MemberID     string          `gorm:"column:member_id" json:"id"`
+	OldReference sql.NullString  `gorm:"column:oldreference_id" json:"-"`
+	NewReference sql.NullString  `gorm:"column:newreference_id" json:"-"`
+	Action       string          `gorm:"column:action" json:"action"`
+	Reason       string          `gorm:"column:reason" json:"reason"`
+	Descendants  int             `gorm:"column:descendants" json:"descendants"`
+	Recomputed   bool            `gorm:"column:recomputed" json:"recomputed"`
+	Clawback     decimal.Decimal `gorm:"column:clawback" json:"clawback"`
+	Reissued     decimal.Decimal `gorm:"column:reissued" json:"reissued"`
+	RebindAfter  *time.Time      `gorm:"column:rebindafter" json:"rebindAfter,omitempty"`
+	CreateTime   time.Time       `gorm:"column:createtime" json:"createTime"`
+}
+
+//ReferenceChangeOutput 推荐关系变更记录输出
+type ReferenceChangeOutput struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	*ReferenceChange
+}
+
+//ReparentResult 调整推荐人返回结果
+type ReparentResult struct {
+	//ChangeID 推荐关系变更记录id
+	ChangeID int
+	//Descendants 一并移动的下级人数
+	Descendants int
+	//Levels 重建的用户关系记录数
+	Levels int
+	//Rebates 转给新上级的返利笔数
+	Rebates int
+	//Clawback 自原上级扣回的返利
+	Clawback Money
+	//Reissued 转给新上级的返利
+	Reissued Money
+	//CapsHit 转给新上级时触发的返利上限
+	CapsHit []RebateCapHit
+}
+
+//subtreeSQL 由members.reference_id逐级向下查找会员及其全部下级
+const subtreeSQL = `with recursive down(id) as (
+ select id from members where id=?
+ union
+ select m.id from down join members m on m.reference_id=down.id)`
+
+//subtreeLevelsSQL 会员及其全部下级由members.reference_id推导的关系(含自己, 第0代), 代数小于返利层数
+const subtreeLevelsSQL = subtreeSQL + `, anc(son,ancestor,gen) as (
+ select id,id,0 from down
+ union all
+ select anc.son,m.reference_id,anc.gen+1 from anc join members m on m.id=anc.ancestor where m.reference_id is not null and anc.gen+1<?)
+ select son sonnode_id,ancestor ancestornode_id,gen generations from anc order by son,gen`
+
+//Reparent 调整会员推荐人, 会员及其全部下级一并移至新推荐人下, 单一事务内完成:
+//	按全部上级检查循环推荐, 删除并重建会员及各下级的user_levels, 记录推荐关系变更
+//	recompute 为false时历史返利不变; 为true时按代数转移历史返利, 见moveRebates
+//	return result, code, message
+func Reparent(db *gorm.DB, mid string, ref string, reason string, recompute bool) (*ReparentResult, string, string) {
+	if len(mid) == 0 || len(ref) == 0 {
+		return nil, ResInvalid, "id or ref不能为空"
+	}
+	tx := db.Begin() //开启事务
+	result, code, msg := reparent(tx, mid, ref, reason, recompute)
+	if code != ResOK {
+		tx.Rollback()
+		return nil, code, msg
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	return result, ResOK, "OK"
+}
+
+func reparent(tx *gorm.DB, mid string, ref string, reason string, recompute bool) (*ReparentResult, string, string) {
+	if err := lockTree(tx); err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	m := NewMember()
+	if err := m.FindByID(tx, mid); err != nil {
+		return nil, ResNotFound, "会员不存在" + mid
+	}
+	r := NewMember()
+	if err := r.FindByID(tx, ref); err != nil {
+		return nil, ResNotFound, "推荐会员不存在" + ref
+	}
+	if m.Reference.Valid && m.Reference.String == r.ID {
+		return nil, ResInvalid, "推荐人未变更"
+	}
+	//新推荐人不能是会员自己或其下级
+	isAncestor, err := checkAncestor(tx, r.ID, m.ID)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	if isAncestor {
+		return nil, ResInvalid, "不能循环推荐"
+	}
+	ids, err := subtreeIDs(tx, m.ID)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	//原关系中下级树之外的上级, 转移返利时据此确定代数
+	var old []UserLevel
+	if recompute {
+		if db1 := tx.Find(&old, "sonnode_id in (?) and ancestornode_id not in (?)", ids, ids); db1.Error != nil {
+			return nil, ResWrongSQL, db1.Error.Error()
+		}
+	}
+	c := &ReferenceChange{MemberID: m.ID, OldReference: m.Reference, Action: RefChangeReparent, Reason: reason,
+		Descendants: len(ids) - 1, Recomputed: recompute, Clawback: zero, Reissued: zero, CreateTime: time.Now()}
+	c.NewReference.Scan(r.ID)
+	if db1 := tx.Model(m).Update("reference_id", r.ID); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	levels, err := rebuildSubtreeLevels(tx, m.ID, ids)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	result := &ReparentResult{Descendants: c.Descendants, Levels: levels, Clawback: NewMoney(zero), Reissued: NewMoney(zero)}
+	if recompute {
+		var n int
+		if c.Clawback, c.Reissued, n, result.CapsHit, err = moveRebates(tx, ids, old); err != nil {
+			return nil, ResWrongSQL, err.Error()
+		}
+		result.Rebates = n
+		result.Clawback = NewMoney(c.Clawback)
+		result.Reissued = NewMoney(c.Reissued)
+	}
+	if db1 := tx.Create(c); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	result.ChangeID = c.ID
+	return result, ResOK, "OK"
+}
+
+//UnbindResult 解除推荐关系返回结果
+type UnbindResult struct {
+	//ChangeID 推荐关系变更记录id
+	ChangeID int
+	//Descendants 一并脱离的下级人数
+	Descendants int
+	//Levels 删除的用户关系记录数
+	Levels int
+	//RebindAfter 冷静期截止时间, 为空不限制
+	RebindAfter *time.Time
+}
+
+//Unbind 解除会员与推荐人的关系, 会员及其全部下级成为独立的树, 单一事务内完成:
+//	删除会员及各下级在会员之上的user_levels(下级之间的关系不变), 历史返利不变, 记录原因
+//	days 冷静期天数, 期间不能重新绑定推荐人; 为空时按RebindCoolingDays配置, <=0 不限制
+//	return result, code, message
+func Unbind(db *gorm.DB, mid string, reason string, days string) (*UnbindResult, string, string) {
+	if len(mid) == 0 {
+		return nil, ResInvalid, "id不能为空"
+	}
+	if len(reason) == 0 {
+		return nil, ResInvalid, "解绑原因不能为空"
+	}
+	var n int
+	if len(days) == 0 {
+		n = GetSettingInt(db, RebindCoolingDays, 0)
+	} else {
+		var err error
+		if n, err = strconv.Atoi(days); err != nil {
+			return nil, ResInvalid, "无效冷静期天数" + days
+		}
+	}
+	tx := db.Begin() //开启事务
+	result, code, msg := unbind(tx, mid, reason, n)
+	if code != ResOK {
+		tx.Rollback()
+		return nil, code, msg
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	return result, ResOK, "OK"
+}
+
+func unbind(tx *gorm.DB, mid string, reason string, days int) (*UnbindResult, string, string) {
+	if err := lockTree(tx); err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	m := NewMember()
+	if err := m.FindByID(tx, mid); err != nil {
+		return nil, ResNotFound, "会员不存在" + mid
+	}
+	if !m.Reference.Valid {
+		return nil, ResInvalid, "用户无推荐用户"
+	}
+	ids, err := subtreeIDs(tx, m.ID)
+	if err != nil {
+		return nil, ResWrongSQL, err.Error()
+	}
+	now := time.Now()
+	c := &ReferenceChange{MemberID: m.ID, OldReference: m.Reference, Action: RefChangeUnbind, Reason: reason,
+		Descendants: len(ids) - 1, Clawback: zero, Reissued: zero, CreateTime: now}
+	if days > 0 {
+		d := now.AddDate(0, 0, days)
+		c.RebindAfter = &d
+	}
+	if db1 := tx.Model(m).Update("reference_id", gorm.Expr("null")); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	db1 := tx.Delete(UserLevel{}, "sonnode_id in (?) and ancestornode_id not in (?)", ids, ids)
+	if db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	levels := int(db1.RowsAffected)
+	if db1 = tx.Create(c); db1.Error != nil {
+		return nil, ResWrongSQL, db1.Error.Error()
+	}
+	return &UnbindResult{c.ID, c.Descendants, levels, c.RebindAfter}, ResOK, "OK"
+}
+
+//checkRebind 会员最近一次解绑的冷静期是否已过, 冷静期内返回错误
+func checkRebind(db *gorm.DB, mid string) error {
+	c := &ReferenceChange{}
+	db1 := db.Where("member_id=? and action=?", mid, RefChangeUnbind).Order("id desc").First(c)
+	if db1.RecordNotFound() {
+		return nil
+	}
+	if db1.Error != nil {
+		return db1.Error
+	}
+	if c.RebindAfter != nil && c.RebindAfter.After(time.Now()) {
+		return errors.New("解绑冷静期内, " + c.RebindAfter.Format("2006-01-02 15:04") + "之后可重新绑定")
+	}
+	return nil
+}
+
+//subtreeIDs 会员及其全部下级id
+func subtreeIDs(db *gorm.DB, mid string) ([]string, error) {
+	var ms []Member
+	if db1 := db.Raw(subtreeSQL+" select id from down", mid).Scan(&ms); db1.Error != nil {
+		return nil, db1.Error
+	}
+	ids := make([]string, len(ms))
+	for i, m := range ms {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
+
+//rebuildSubtreeLevels 按members.reference_id重建会员及其全部下级(ids)的user_levels记录, 返回记录数
+func rebuildSubtreeLevels(tx *gorm.DB, mid string, ids []string) (int, error) {
+	if db1 := tx.Delete(UserLevel{}, "sonnode_id in (?)", ids); db1.Error != nil {
+		return 0, db1.Error
+	}
+	var es []UserLevel
+	if db1 := tx.Raw(subtreeLevelsSQL, mid, len(getLevelRatios())).Scan(&es); db1.Error != nil {
+		return 0, db1.Error
+	}
+	for _, e := range es {
+		u := &UserLevel{}
+		if u.AddNewUserLevel(tx, e.SonID, e.AncestorID, e.Generations) {
+			return 0, errors.New("user level 创建失败")
+		}
+	}
+	return len(es), nil
+}
+
+//moveRebates 按代数转移下级树(ids)产生的历史返利
+//	原上级(下级树之外)所得返利中未扣回部分全部扣回, 以相同金额, 分成比例, 钱包及促销活动转给新关系中同一代的上级;
+//	新关系无该代上级时仅扣回; 原关系表中无对应上级的返利(历史数据)不做处理
+//	转移的返利按订单检查返利上限, 超出上限的部分只扣回不转移; 每日/每月上限按原返利时间所在日/月统计,
+//	含本次已转给同一上级的返利; 转移的返利按到账期限重新生效
+//	old 调整前的关系(下级树之外的上级), 须在重建user_levels之前读取
+//	return 扣回合计, 转移合计, 转移笔数, 触发的返利上限, error
+func moveRebates(tx *gorm.DB, ids []string, old []UserLevel) (decimal.Decimal, decimal.Decimal, int, []RebateCapHit, error) {
+	key := func(son string, gen int) string {
+		return son + ":" + strconv.Itoa(gen)
+	}
+	gens := make(map[string]int, len(old))
+	for _, u := range old {
+		gens[u.SonID+":"+u.AncestorID] = u.Generations
+	}
+	var cur []UserLevel
+	if db1 := tx.Find(&cur, "sonnode_id in (?) and ancestornode_id not in (?)", ids, ids); db1.Error != nil {
+		return zero, zero, 0, nil, db1.Error
+	}
+	heirs := make(map[string]string, len(cur))
+	for _, u := range cur {
+		heirs[key(u.SonID, u.Generations)] = u.AncestorID
+	}
+	var rebates []Transaction
+	db1 := tx.Order("transactiontime").Find(&rebates, "trantype=? and reverse_id is null and source_id in (?) and target_id not in (?)",
+		TranRebate, ids, ids)
+	if db1.Error != nil {
+		return zero, zero, 0, nil, db1.Error
+	}
+	clawback := zero
+	//orders 转给新上级的返利, 按消费会员及订单分组; keys 保持原返利顺序
+	orders := map[string][]Transaction{}
+	var keys []string
+	for i := range rebates {
+		g, ok := gens[rebates[i].SourceID+":"+rebates[i].TargetID]
+		if !ok {
+			continue
+		}
+		left, err := reversedAmount(tx, rebates[i].ID)
+		if err != nil {
+			return zero, zero, 0, nil, err
+		}
+		left = rebates[i].Amount.Add(left)
+		if !left.IsPositive() {
+			continue
+		}
+		//扣回返利需扣减原上级账户记录, 同样锁定原上级会员
+		if err = lockMember(tx, rebates[i].TargetID); err != nil {
+			return zero, zero, 0, nil, err
+		}
+		orderID := rebates[i].OrderID.String
+		if _, err = clawbackRebate(tx, &rebates[i], left, orderID); err != nil {
+			return zero, zero, 0, nil, err
+		}
+		clawback = clawback.Add(left)
+		heir, ok := heirs[key(rebates[i].SourceID, g)]
+		if !ok {
+			continue
+		}
+		t := Transaction{}
+		t.fillTransaction(orderID, rebates[i].SourceID, heir, left, TranRebate)
+		t.Ratio = rebates[i].Ratio
+		t.WalletType = rebates[i].WalletType
+		t.CampaignID = rebates[i].CampaignID
+		t.Generation = g
+		t.capTime = rebates[i].TransactionTime
+		k := t.SourceID + ":" + orderID
+		if _, ok := orders[k]; !ok {
+			keys = append(keys, k)
+		}
+		orders[k] = append(orders[k], t)
+	}
+	//新上级累计收入须串行检查
+	var heirIDs []string
+	for _, k := range keys {
+		for _, t := range orders[k] {
+			heirIDs = append(heirIDs, t.TargetID)
+		}
+	}
+	if err := lockMembers(tx, heirIDs); err != nil {
+		return zero, zero, 0, nil, err
+	}
+	//moved 本次已转给各上级的返利, 按上限统计区间; 转移的返利以当前时间入账, 不计入原统计区间的已得返利
+	moved := map[string]decimal.Decimal{}
+	movedKey := func(t *Transaction, cap string) string {
+		from, _ := capPeriod(cap, t.capTime)
+		return t.TargetID + ":" + cap + ":" + from.Format("2006-01-02")
+	}
+	earned := func(t *Transaction, cap string) (decimal.Decimal, error) {
+		e, err := rebateEarned(tx, t, cap)
+		return e.Add(moved[movedKey(t, cap)]), err
+	}
+	//返利上限按订单计算
+	caps := getRebateCaps(tx)
+	var ts []Transaction
+	var hits []RebateCapHit
+	for _, k := range keys {
+		rs, hs, err := caps.apply(orders[k], earned)
+		if err != nil {
+			return zero, zero, 0, nil, err
+		}
+		for i := range rs {
+			for _, cap := range []string{CapDaily, CapMonthly} {
+				mk := movedKey(&rs[i], cap)
+				moved[mk] = moved[mk].Add(rs[i].Amount)
+			}
+		}
+		ts = append(ts, rs...)
+		hits = append(hits, hs...)
+	}
+	accounts := getAccountPoints(tx, ts)
+	if err := saveConsume(tx, nil, ts, accounts, nil, nil); err != nil {
+		return zero, zero, 0, nil, err
+	}
+	return clawback, totalTransactions(ts), len(ts), hits, nil
+}
+
+//GetReferenceChanges 推荐关系变更记录, 新的在前; mID为空时全部会员
+func GetReferenceChanges(db *gorm.DB, mID string, pageSize int, offset int) ([]ReferenceChangeOutput, error) {
+	if pageSize <= 0 || pageSize > defaultPageSize {
+		pageSize = defaultPageSize
+	}
+	var cs []ReferenceChange
+	db1 := db
+	if len(mID) > 0 {
+		db1 = db1.Where("member_id=?", mID)
+	}
+	if db1 = db1.Order("id desc").Limit(pageSize).Offset(offset).Find(&cs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	outs := make([]ReferenceChangeOutput, len(cs))
+	for i := range cs {
+		outs[i] = ReferenceChangeOutput{cs[i].OldReference.String, cs[i].NewReference.String, &cs[i]}
+	}
+	return outs, nil
+}
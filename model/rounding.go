@@ -0,0 +1,139 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//RebateRounding 返利金额舍入方式配置code, 见RoundHalfUp/RoundBankers/RoundTruncate
+	RebateRounding = "RebateRounding"
+	//RebateResidual 返利舍入差额归属配置code, 见ResidualLast/ResidualPlatform
+	RebateResidual = "RebateResidual"
+
+	//RoundHalfUp 四舍五入
+	RoundHalfUp = "half_up"
+	//RoundBankers 银行家舍入, 四舍六入五取偶
+	RoundBankers = "bankers"
+	//RoundTruncate 截断
+	RoundTruncate = "truncate"
+
+	//ResidualLast 舍入差额计入最后一级返利
+	ResidualLast = "last"
+	//ResidualPlatform 舍入差额计入平台舍入差额科目
+	ResidualPlatform = "platform"
+)
+
+//roundAmount 按舍入方式舍入到金额精度amountScale
+func roundAmount(d decimal.Decimal, policy string) decimal.Decimal {
+	switch policy {
+	case RoundBankers:
+		return d.RoundBank(amountScale)
+	case RoundTruncate:
+		return d.Truncate(amountScale)
+	default:
+		return d.Round(amountScale)
+	}
+}
+
+//applyResidual 舍入差额计入最后一级返利; 计入后返利不为正数时(负差额), 依次改为计入上一级返利,
+//	均不能承担时返回该差额, 由平台承担; 返回未计入的差额
+func applyResidual(ts []Transaction, residual decimal.Decimal) decimal.Decimal {
+	for i := len(ts) - 1; i >= 0 && !residual.Equal(zero); i-- {
+		if a := ts[i].Amount.Add(residual); a.IsPositive() {
+			ts[i].Amount = a
+			return zero
+		}
+	}
+	return residual
+}
+
+//rebateResidual 返利舍入差额, 在返利上限(applyRebateCaps)之后计算
+//	未被削减的常规返利及rest(未产生交易的层级), sum(未舍入金额) 舍入后与其返利合计的差额;
+//	被削减的返利金额即上限金额, 不计差额; 促销活动返利不计差额
+//	policy=ResidualLast 计入最后一级未被削减的返利(见applyResidual); 否则或不能计入时返回差额, 由平台承担
+func rebateResidual(ts []Transaction, rest decimal.Decimal, rounding string, policy string) decimal.Decimal {
+	var idx []int
+	exact, total := rest, zero
+	for i := range ts {
+		if ts[i].CampaignID.Valid || ts[i].capped {
+			continue
+		}
+		exact = exact.Add(ts[i].exact)
+		total = total.Add(ts[i].Amount)
+		idx = append(idx, i)
+	}
+	residual := roundAmount(exact, rounding).Sub(total)
+	if policy != ResidualLast {
+		return residual
+	}
+	rs := make([]Transaction, len(idx))
+	for j, i := range idx {
+		rs[j] = ts[i]
+	}
+	residual = applyResidual(rs, residual)
+	for j, i := range idx {
+		ts[i].Amount = rs[j].Amount
+	}
+	return residual
+}
+
+//rebateRounding 当前返利舍入方式
+func rebateRounding(db *gorm.DB) string {
+	return GetSettingString(db, RebateRounding, RoundHalfUp)
+}
+
+//clawbackAmounts 部分退款各笔返利的扣回金额, 按 退款对应的返利基数base*Ratio 舍入, 不超过剩余返利lefts
+//	常规返利(非促销活动)扣回合计与 sum(base*Ratio) 舍入后的差额, 同createTransactionsByLevels:
+//	policy=ResidualLast 由最后一笔起计入可承担的常规返利扣回; 否则或均不能承担时返回该差额residual, 由平台承担
+func clawbackAmounts(base decimal.Decimal, ts []Transaction, lefts []decimal.Decimal, rounding string, policy string) (ds []decimal.Decimal, residual decimal.Decimal) {
+	ds = make([]decimal.Decimal, len(ts))
+	exact, total := zero, zero
+	var regular []int
+	for i := range ts {
+		e := base.Mul(ts[i].Ratio)
+		ds[i] = decimal.Min(roundAmount(e, rounding), lefts[i])
+		if !ts[i].CampaignID.Valid {
+			exact = exact.Add(e)
+			total = total.Add(ds[i])
+			regular = append(regular, i)
+		}
+	}
+	residual = roundAmount(exact, rounding).Sub(total)
+	if policy != ResidualLast {
+		return ds, residual
+	}
+	for j := len(regular) - 1; j >= 0 && !residual.Equal(zero); j-- {
+		i := regular[j]
+		if d := ds[i].Add(residual); !d.IsNegative() && d.LessThanOrEqual(lefts[i]) {
+			ds[i] = d
+			residual = zero
+		}
+	}
+	return ds, residual
+}
+
+//orderResidual 订单已过账的平台舍入差额, 舍入差额科目借方余额; 按关联交易(该订单的返利及扣回)汇总
+func orderResidual(db *gorm.DB, mID string, orderID string) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("postings p").Select("coalesce(sum(p.amount),0) as sumamount")
+	db1 = db1.Joins("join journal_entries e on e.id=p.entry_id join transactions t on t.id=e.transaction_id")
+	if db1 = db1.Where("p.account=? and t.source_id=? and t.order_id=?", LedgerRounding, mID, orderID).Scan(&a); db1.Error != nil {
+		return zero, db1.Error
+	}
+	return a.Amount, nil
+}
+
+//postRebateResidual 平台承担的返利舍入差额过账: 借返利费用, 贷舍入差额科目; 负数为冲回
+//	tID 关联该订单最后一笔返利交易
+func postRebateResidual(db *gorm.DB, tID string, residual decimal.Decimal) error {
+	if residual.Equal(zero) {
+		return nil
+	}
+	ps := []Posting{
+		newPosting(LedgerRebateExpense, "", residual),
+		newPosting(LedgerRounding, "", residual.Neg()),
+	}
+	return postEntry(db, tID, TranRebate, ps)
+}
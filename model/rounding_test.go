@@ -0,0 +1,116 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRoundAmount(t *testing.T) {
+	cases := []struct {
+		in, policy string
+		want       string
+	}{
+		{"0.005", RoundHalfUp, "0.01"},
+		{"0.004", RoundHalfUp, "0"},
+		{"-0.005", RoundHalfUp, "-0.01"},
+		{"0.005", RoundBankers, "0"},
+		{"0.015", RoundBankers, "0.02"},
+		{"0.025", RoundBankers, "0.02"},
+		{"0.019", RoundTruncate, "0.01"},
+		{"-0.019", RoundTruncate, "-0.01"},
+		{"1.235", "", "1.24"},
+		{"1.235", "unknown", "1.24"},
+	}
+	for _, c := range cases {
+		if got := roundAmount(decimal.RequireFromString(c.in), c.policy); !got.Equal(decimal.RequireFromString(c.want)) {
+			t.Errorf("roundAmount(%s, %q) = %s, want %s", c.in, c.policy, got, c.want)
+		}
+	}
+}
+
+func TestApplyResidual(t *testing.T) {
+	cases := []struct {
+		name     string
+		amounts  []string
+		residual string
+		want     []string
+		left     string
+	}{
+		{"none", []string{"0.01", "0.01"}, "0", []string{"0.01", "0.01"}, "0"},
+		{"positive to last", []string{"0.01", "0.01"}, "0.01", []string{"0.01", "0.02"}, "0"},
+		{"negative to last", []string{"0.05", "0.03"}, "-0.01", []string{"0.05", "0.02"}, "0"},
+		{"last would be zero", []string{"0.02", "0.01"}, "-0.01", []string{"0.01", "0.01"}, "0"},
+		//3级各0.005, half_up各舍入为0.01, 合计舍入为0.02, 差额-0.01, 由平台承担
+		{"all too small", []string{"0.01", "0.01", "0.01"}, "-0.01", []string{"0.01", "0.01", "0.01"}, "-0.01"},
+		{"no rebates", nil, "0.01", nil, "0.01"},
+	}
+	for _, c := range cases {
+		ts := make([]Transaction, len(c.amounts))
+		for i, a := range c.amounts {
+			ts[i].Amount = decimal.RequireFromString(a)
+		}
+		left := applyResidual(ts, decimal.RequireFromString(c.residual))
+		if !left.Equal(decimal.RequireFromString(c.left)) {
+			t.Errorf("%s: residual left %s, want %s", c.name, left, c.left)
+		}
+		for i, w := range c.want {
+			if !ts[i].Amount.Equal(decimal.RequireFromString(w)) {
+				t.Errorf("%s: ts[%d] = %s, want %s", c.name, i, ts[i].Amount, w)
+			}
+		}
+	}
+}
+
+func TestClawbackAmounts(t *testing.T) {
+	rebate := func(ratio string, campaign bool) Transaction {
+		t := Transaction{Ratio: decimal.RequireFromString(ratio)}
+		t.CampaignID.Valid = campaign
+		return t
+	}
+	cases := []struct {
+		name     string
+		base     string
+		ts       []Transaction
+		lefts    []string
+		policy   string
+		want     []string
+		residual string
+	}{
+		{"even", "100", []Transaction{rebate("0.1", false), rebate("0.05", false)}, []string{"20", "10"},
+			ResidualLast, []string{"10", "5"}, "0"},
+		//各级0.0035, 0.00175, 0.000875 舍入为0.04, 0.02, 0.01, 合计舍入为0.06, 差额-0.01
+		{"uneven last", "0.35", []Transaction{rebate("0.1", false), rebate("0.05", false), rebate("0.025", false)},
+			[]string{"10", "5", "2.5"}, ResidualLast, []string{"0.04", "0.02", "0"}, "0"},
+		{"uneven platform", "0.35", []Transaction{rebate("0.1", false), rebate("0.05", false), rebate("0.025", false)},
+			[]string{"10", "5", "2.5"}, ResidualPlatform, []string{"0.04", "0.02", "0.01"}, "-0.01"},
+		//3级各0.005舍入为0.01, 合计舍入为0.02; 最后一级可承担
+		{"three halves", "0.05", []Transaction{rebate("0.1", false), rebate("0.1", false), rebate("0.1", false)},
+			[]string{"10", "10", "10"}, ResidualLast, []string{"0.01", "0.01", "0"}, "0"},
+		//剩余返利不足时按剩余扣回, 少扣回部分抵消舍入差额
+		{"left limits", "0.35", []Transaction{rebate("0.1", false), rebate("0.05", false), rebate("0.025", false)},
+			[]string{"10", "5", "0"}, ResidualLast, []string{"0.04", "0.02", "0"}, "0"},
+		{"positive residual up", "1", []Transaction{rebate("0.1", false), rebate("0.1", false)},
+			[]string{"0.5", "0.05"}, ResidualLast, []string{"0.15", "0.05"}, "0"},
+		{"nothing left", "1", []Transaction{rebate("0.1", false), rebate("0.1", false)},
+			[]string{"0.1", "0.05"}, ResidualLast, []string{"0.1", "0.05"}, "0.05"},
+		//促销活动返利不计舍入差额
+		{"campaign excluded", "0.05", []Transaction{rebate("0.1", false), rebate("0.1", true)},
+			[]string{"10", "10"}, ResidualPlatform, []string{"0.01", "0.01"}, "0"},
+	}
+	for _, c := range cases {
+		lefts := make([]decimal.Decimal, len(c.lefts))
+		for i, l := range c.lefts {
+			lefts[i] = decimal.RequireFromString(l)
+		}
+		ds, residual := clawbackAmounts(decimal.RequireFromString(c.base), c.ts, lefts, RoundHalfUp, c.policy)
+		if !residual.Equal(decimal.RequireFromString(c.residual)) {
+			t.Errorf("%s: residual %s, want %s", c.name, residual, c.residual)
+		}
+		for i, w := range c.want {
+			if !ds[i].Equal(decimal.RequireFromString(w)) {
+				t.Errorf("%s: ds[%d] = %s, want %s", c.name, i, ds[i], w)
+			}
+		}
+	}
+}
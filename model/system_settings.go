@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
@@ -42,6 +43,29 @@ func (o *SystemSettings) FindByCode(db *gorm.DB, code string) (*SystemSettings,
 	return o, nil
 }
 
+//GetSettingInt 获取整型配置; 不存在或格式错误时, 返回缺省值def
+func GetSettingInt(db *gorm.DB, code string, def int) int {
+	ss := NewSystemSettings()
+	if _, err := ss.FindByCode(db, code); err != nil {
+		return def
+	}
+	v, err := strconv.Atoi(ss.Value)
+	if err != nil {
+		log.Printf("Config %s warning: invalid int value %s", code, ss.Value)
+		return def
+	}
+	return v
+}
+
+//GetSettingString 获取字符串配置; 不存在时, 返回缺省值def
+func GetSettingString(db *gorm.DB, code string, def string) string {
+	ss := NewSystemSettings()
+	if _, err := ss.FindByCode(db, code); err != nil {
+		return def
+	}
+	return ss.Value
+}
+
 //UpdateRatios 更新费率
 // return code, msg
 func UpdateRatios(db *gorm.DB, r []string, sync, updAll string) (string, string) {
@@ -64,7 +88,6 @@ func UpdateRatios(db *gorm.DB, r []string, sync, updAll string) (string, string)
 			return ResFail, err.Error()
 		}
 	}
-	mask = make([]bool, len(levelRatios))
 	//total := zero
 	onepercent := decimal.New(1, -2)
 	for i := l; i > 0; {
@@ -85,16 +108,22 @@ func UpdateRatios(db *gorm.DB, r []string, sync, updAll string) (string, string)
 		}
 		tmp[i] = onepercent.Mul(tmp[i])
 		//total = total.Add(tmp[i])
+	}
+	if l < len(r) {
+		tmp = tmp[:l]
+	}
+	//比较及替换须持有写锁, 保证mask与被替换的分成比例一致
+	ratiosMutex.Lock()
+	defer ratiosMutex.Unlock()
+	mask = make([]bool, len(levelRatios))
+	for i := range tmp {
 		if i < len(mask) {
 			mask[i] = !levelRatios[i].Equal(tmp[i])
 			needUpdate = needUpdate || mask[i]
 		}
 	}
-	if l < len(r) {
-		tmp = tmp[:l]
-		if l < len(mask) {
-			mask = mask[:l]
-		}
+	if l < len(r) && l < len(mask) {
+		mask = mask[:l]
 	}
 	if !needUpdate && l == len(mask) {
 		return ResOK, "无需更新"
@@ -104,10 +133,10 @@ func UpdateRatios(db *gorm.DB, r []string, sync, updAll string) (string, string)
 		return ResFail, err.Error()
 	}
 	oldRatios := levelRatios
-	InitLevelRatios(&tmp)
+	setLevelRatios(tmp)
 	if updateExist {
 		//耗时操作,异步进行
-		go updateAllUserLevel(db, mask, oldRatios, updateAll)
+		go updateAllUserLevel(db, mask, oldRatios, tmp, updateAll)
 		return ResOK, "更新中"
 	}
 	return ResOK, "更新成功"
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//TierWindowDays 会员等级评定的消费统计天数配置code, 自当日起往前计算
+	TierWindowDays = "TierWindowDays"
+
+	//TierReasonAuto 等级变更原因: 定时任务按规则评定
+	TierReasonAuto = "auto"
+)
+
+//TierRule 会员等级评定规则, 同时满足全部条件的最高等级为会员等级
+//	MinSpend 统计期内累计消费(扣除退款), MinTeamVolume 统计期内下级(用户关系表内各代)累计消费, 分为单位
+//	MinReferrals 直接推荐人数; 均为0的规则为基础等级, 不满足任何规则的会员等级被清除
+type TierRule struct {
+	Level         int             `gorm:"column:level" json:"level"`
+	MinSpend      decimal.Decimal `gorm:"column:minspend" json:"minspend"`
+	MinReferrals  int             `gorm:"column:minreferrals" json:"minreferrals"`
+	MinTeamVolume decimal.Decimal `gorm:"column:minteamvolume" json:"minteamvolume"`
+}
+
+//TierChange 会员等级变更记录, 含评定时的统计值
+type TierChange struct {
+	ID         int             `gorm:"column:id" json:"-"`
+	MemberID   string          `gorm:"column:member_id" json:"id"`
+	FromLevel  sql.NullString  `gorm:"column:fromlevel" json:"-"`
+	ToLevel    sql.NullString  `gorm:"column:tolevel" json:"-"`
+	Spend      decimal.Decimal `gorm:"column:spend" json:"spend"`
+	Referrals  int             `gorm:"column:referrals" json:"referrals"`
+	TeamVolume decimal.Decimal `gorm:"column:teamvolume" json:"teamvolume"`
+	Reason     string          `gorm:"column:reason" json:"reason"`
+	CreateTime time.Time       `gorm:"column:createtime" json:"createTime"`
+}
+
+//TierChangeOutput 会员等级变更记录输出
+type TierChangeOutput struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	*TierChange
+}
+
+//evaluateTiersSQL 按规则评定全部会员等级, 单条语句完成统计, 记录变更及更新members.level
+//	参数: 订单类型, 统计天数, 变更原因
+const evaluateTiersSQL = `with spend as (
+	select member_id, sum(amount-refunded) amount from orders
+	where ordertype=? and createtime>=current_date-?::integer group by member_id
+), refs as (
+	select reference_id member_id, count(*) n from members where reference_id is not null group by reference_id
+), team as (
+	select ul.ancestornode_id member_id, sum(s.amount) amount from user_levels ul join spend s on s.member_id=ul.sonnode_id
+	where ul.generations>0 group by ul.ancestornode_id
+), target as (
+	select m.id, m.level fromlevel, coalesce(s.amount,0) spend, coalesce(f.n,0) referrals, coalesce(t.amount,0) teamvolume
+	from members m left join spend s on s.member_id=m.id left join refs f on f.member_id=m.id left join team t on t.member_id=m.id
+), rated as (
+	select g.*, (select max(r.level) from tier_rules r
+		where g.spend>=r.minspend and g.referrals>=r.minreferrals and g.teamvolume>=r.minteamvolume) tolevel
+	from target g
+), logged as (
+	insert into tier_changes(member_id,fromlevel,tolevel,spend,referrals,teamvolume,reason,createtime)
+	select id, fromlevel, tolevel, spend, referrals, teamvolume, ?, now() from rated where tolevel is distinct from fromlevel
+)
+update members m set level=c.tolevel from rated c where m.id=c.id and c.tolevel is distinct from c.fromlevel`
+
+//EvaluateTiers 按等级评定规则更新全部会员等级, 并记录变更
+//	无评定规则时不做处理(保留手工设置的等级); 下级消费按用户关系表统计, 深度同分成比例层数
+//	返回等级变更的会员数
+func EvaluateTiers(db *gorm.DB) (int, error) {
+	var n int
+	if db1 := db.Model(&TierRule{}).Count(&n); db1.Error != nil || n == 0 {
+		return 0, db1.Error
+	}
+	days := GetSettingInt(db, TierWindowDays, 365)
+	db1 := db.Exec(evaluateTiersSQL, TranConsume, days, TierReasonAuto)
+	if db1.Error != nil {
+		return 0, db1.Error
+	}
+	return int(db1.RowsAffected), nil
+}
+
+//GetTierRules 全部等级评定规则, 按等级
+func GetTierRules(db *gorm.DB) ([]TierRule, error) {
+	var rs []TierRule
+	if db1 := db.Order("level").Find(&rs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	return rs, nil
+}
+
+//SetTierRule 设置等级评定规则, 等级唯一; remove 为true时删除该规则
+//	minSpend, minTeamVolume 分为单位, 空字符串视为0
+//	return code, message
+func SetTierRule(db *gorm.DB, level string, minSpend string, minReferrals string, minTeamVolume string, remove bool) (string, string) {
+	lv, err := strconv.Atoi(level)
+	if err != nil {
+		return ResInvalid, "无效会员等级" + level
+	}
+	if remove {
+		db1 := db.Delete(TierRule{}, "level=?", lv)
+		if db1.Error != nil {
+			return ResWrongSQL, db1.Error.Error()
+		}
+		if db1.RowsAffected == 0 {
+			return ResNotFound, "规则不存在"
+		}
+		return ResOK, "更新成功"
+	}
+	amountOf := func(v string) (decimal.Decimal, error) {
+		if len(v) == 0 {
+			return zero, nil
+		}
+		d, err := decimal.NewFromString(v)
+		if err == nil && d.IsNegative() {
+			err = errors.New("规则值不能为负数")
+		}
+		return d, err
+	}
+	r := &TierRule{Level: lv}
+	if r.MinSpend, err = amountOf(minSpend); err != nil {
+		return ResInvalid, err.Error()
+	}
+	if r.MinTeamVolume, err = amountOf(minTeamVolume); err != nil {
+		return ResInvalid, err.Error()
+	}
+	if len(minReferrals) > 0 {
+		if r.MinReferrals, err = strconv.Atoi(minReferrals); err != nil || r.MinReferrals < 0 {
+			return ResInvalid, "无效推荐人数" + minReferrals
+		}
+	}
+	tx := db.Begin() //开启事务
+	if db1 := tx.Delete(TierRule{}, "level=?", lv); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 := tx.Create(r); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return ResOK, "更新成功"
+}
+
+//GetTierChanges 会员等级变更记录, 新的在前; mID为空时全部会员
+func GetTierChanges(db *gorm.DB, mID string, pageSize int, offset int) ([]TierChangeOutput, error) {
+	if pageSize <= 0 || pageSize > defaultPageSize {
+		pageSize = defaultPageSize
+	}
+	var cs []TierChange
+	db1 := db
+	if len(mID) > 0 {
+		db1 = db1.Where("member_id=?", mID)
+	}
+	if db1 = db1.Order("id desc").Limit(pageSize).Offset(offset).Find(&cs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	outs := make([]TierChangeOutput, len(cs))
+	for i := range cs {
+		outs[i] = TierChangeOutput{cs[i].FromLevel.String, cs[i].ToLevel.String, &cs[i]}
+	}
+	return outs, nil
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+//TierRatio 会员等级分成比例, 等级(members.level) x 代数
+//	获得返利的上级会员等级有配置时, 按该等级各代比例返利(未配置的代数为0), 否则按全局分成比例
+type TierRatio struct {
+	Level      string          `gorm:"column:level"`
+	Generation int             `gorm:"column:generation"`
+	Ratio      decimal.Decimal `gorm:"column:ratio"`
+	UpdTime    time.Time       `gorm:"column:updtime"`
+}
+
+var (
+	//tierRatios 等级分成比例, level -> 各代比例
+	tierRatios = map[string][]decimal.Decimal{}
+	tierMutex  sync.RWMutex
+)
+
+//InitTierRatios 加载等级分成比例
+func InitTierRatios(db *gorm.DB) error {
+	var trs []TierRatio
+	if db1 := db.Order("level, generation").Find(&trs); db1.Error != nil {
+		log.Printf("tier ratios load error: %s", db1.Error)
+		return db1.Error
+	}
+	m := map[string][]decimal.Decimal{}
+	for _, tr := range trs {
+		rs := m[tr.Level]
+		for len(rs) <= tr.Generation {
+			rs = append(rs, zero)
+		}
+		rs[tr.Generation] = tr.Ratio
+		m[tr.Level] = rs
+	}
+	tierMutex.Lock()
+	tierRatios = m
+	tierMutex.Unlock()
+	ratiosMutex.Lock()
+	buildRatiosJSON()
+	ratiosMutex.Unlock()
+	return nil
+}
+
+//tierRatio 上级会员等级对应第generation代分成比例, 等级无配置时为全局分成比例
+func tierRatio(level string, generation int) decimal.Decimal {
+	tierMutex.RLock()
+	rs, ok := tierRatios[level]
+	tierMutex.RUnlock()
+	if !ok {
+		rs = getLevelRatios()
+	}
+	if generation < len(rs) {
+		return rs[generation]
+	}
+	return zero
+}
+
+//tierRatiosStrings 等级分成比例输出
+func tierRatiosStrings() map[string][]string {
+	tierMutex.RLock()
+	defer tierMutex.RUnlock()
+	if len(tierRatios) == 0 {
+		return nil
+	}
+	m := make(map[string][]string, len(tierRatios))
+	for level, rs := range tierRatios {
+		strs := make([]string, len(rs))
+		for i, r := range rs {
+			strs[i] = r.String()
+		}
+		m[level] = strs
+	}
+	return m
+}
+
+//buildRatiosJSON 生成分成比例json, 含全局及等级分成比例; 调用方须持有ratiosMutex
+func buildRatiosJSON() {
+	str := make([]string, len(levelRatios))
+	for i, d := range levelRatios {
+		str[i] = d.String()
+	}
+	b, _ := json.Marshal(ratiosOutput{"200", "OK", str, tierRatiosStrings()})
+	ratiosJSON = string(b)
+}
+
+//ancestorTiers 各上级会员等级, ancestor id -> level
+func ancestorTiers(db *gorm.DB, ul []UserLevel) (map[string]string, error) {
+	tierMutex.RLock()
+	empty := len(tierRatios) == 0
+	tierMutex.RUnlock()
+	tiers := make(map[string]string, len(ul))
+	if empty {
+		return tiers, nil
+	}
+	ids := make([]string, len(ul))
+	for i, u := range ul {
+		ids[i] = u.AncestorID
+	}
+	var ms []Member
+	if db1 := db.Select("id, level").Where("id in (?)", ids).Find(&ms); db1.Error != nil {
+		return nil, db1.Error
+	}
+	for _, m := range ms {
+		tiers[m.ID] = m.Level.String
+	}
+	return tiers, nil
+}
+
+//UpdateTierRatios 更新会员等级分成比例
+//	r 各代比例, 百分数, 同UpdateRatios; 为空时删除该等级配置, 恢复按全局分成比例
+//	代数不能超过全局分成比例层数(用户关系表深度)
+// return code, msg
+func UpdateTierRatios(db *gorm.DB, level string, r []string) (string, string) {
+	if _, err := strconv.Atoi(level); err != nil {
+		return ResInvalid, "无效会员等级" + level
+	}
+	n := len(getLevelRatios())
+	if len(r) > n {
+		return ResInvalid, "代数不能超过全局分成比例层数" + strconv.Itoa(n)
+	}
+	onepercent := decimal.New(1, -2)
+	trs := make([]TierRatio, len(r))
+	now := time.Now()
+	for i, str := range r {
+		d, err := decimal.NewFromString(str)
+		if err != nil {
+			return ResInvalid, err.Error()
+		}
+		if d.IsNegative() {
+			return ResInvalid, "分成比例不能为负数"
+		}
+		trs[i] = TierRatio{level, i, onepercent.Mul(d), now}
+	}
+	tx := db.Begin() //开启事务
+	if db1 := tx.Delete(TierRatio{}, "level=?", level); db1.Error != nil {
+		tx.Rollback()
+		return ResWrongSQL, db1.Error.Error()
+	}
+	for i := range trs {
+		if db1 := tx.Create(&trs[i]); db1.Error != nil {
+			tx.Rollback()
+			return ResWrongSQL, db1.Error.Error()
+		}
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	if err := InitTierRatios(db); err != nil {
+		return ResFail, err.Error()
+	}
+	return ResOK, "更新成功"
+}
@@ -14,6 +14,31 @@ import (
 
 const (
 	defaultPageSize = 500
+	//amountScale 金额精度, 与数据库numeric(11,2)一致
+	amountScale = 2
+	//ratioScale 分成比例精度, 与数据库numeric(5,4)一致
+	ratioScale = 4
+
+	//TranRebate 交易类型: 消费返利
+	TranRebate = "rebate"
+	//TranConsume 交易类型: 积分抵用消费
+	TranConsume = "consume"
+	//TranCashout 交易类型: 提现
+	TranCashout = "cashout"
+	//TranExpire 交易类型: 积分过期
+	TranExpire = "expire"
+	//TranClawback 交易类型: 退款扣回返利
+	TranClawback = "clawback"
+	//TranRefund 交易类型: 退款退还抵用积分
+	TranRefund = "refund"
+	//TranAdjust 交易类型: 调整
+	TranAdjust = "adjust"
+	//TranTransferOut 交易类型: 转赠转出
+	TranTransferOut = "transfer_out"
+	//TranTransferIn 交易类型: 转赠转入
+	TranTransferIn = "transfer_in"
+	//TranCashoutFee 交易类型: 提现手续费
+	TranCashoutFee = "cashout_fee"
 )
 
 //Transaction 用户关系表
@@ -23,20 +48,44 @@ type Transaction struct {
 	SourceID        string          `gorm:"column:source_id"`
 	TargetID        string          `gorm:"column:target_id"`
 	Amount          decimal.Decimal `gorm:"column:amount"`
+	TranType        string          `gorm:"column:trantype"`
 	TransactionTime time.Time       `gorm:"column:transactiontime"`
+	//Ratio 返利交易对应的分成比例
+	Ratio decimal.Decimal `gorm:"column:ratio"`
+	//ReverseID 冲正交易对应的原交易id
+	ReverseID sql.NullString `gorm:"column:reverse_id"`
+	//WalletType 钱包类型, 扣减多种钱包时为WalletMixed
+	WalletType string `gorm:"column:wallettype"`
+	//CampaignID 产生该返利的促销活动, 常规返利为空
+	CampaignID sql.NullInt64 `gorm:"column:campaign_id"`
+	//AccountID 过期交易对应的账户记录, 其他交易为空
+	AccountID sql.NullString `gorm:"column:account_id"`
+	//Generation 返利交易对应的代数, 不入库
+	Generation int `gorm:"-"`
+	//exact 返利交易未舍入金额, 用于计算舍入差额, 不入库
+	exact decimal.Decimal
+	//capped 返利被返利上限削减, 不计舍入差额, 不入库
+	capped bool
+	//capTime 上级每日/每月返利上限的统计时间, 转移的返利为原返利时间, 为空时为当前时间, 不入库
+	capTime time.Time
 }
 
 //HistoryTransaction 历史记录视图
 type HistoryTransaction struct {
-	ID              string          `gorm:"column:id" json:"id"`
-	OrderID         sql.NullString  `gorm:"column:order_id"`
-	MemberID        string          `gorm:"column:member_id"`
-	MemberName      string          `gorm:"column:mname" json:"name"`
-	MemberPhone     string          `gorm:"column:phone" json:"phone"`
-	RelationID      string          `gorm:"column:relation_id"`
-	RelationName    string          `gorm:"column:rname" json:"rname"`
-	Amount          decimal.Decimal `gorm:"column:amount" json:"amount"`
-	TransactionTime time.Time       `gorm:"column:transactiontime" json:"time"`
+	ID              string         `gorm:"column:id" json:"id"`
+	OrderID         sql.NullString `gorm:"column:order_id"`
+	MemberID        string         `gorm:"column:member_id"`
+	MemberName      string         `gorm:"column:mname" json:"name"`
+	MemberPhone     string         `gorm:"column:phone" json:"phone"`
+	RelationID      string         `gorm:"column:relation_id"`
+	RelationName    string         `gorm:"column:rname" json:"rname"`
+	Amount          Money          `gorm:"column:amount" json:"amount"`
+	TranType        string         `gorm:"column:trantype" json:"type"`
+	Reason          string         `gorm:"column:reason" json:"reason"`
+	WalletType      string         `gorm:"column:wallettype" json:"wallet"`
+	CampaignID      sql.NullInt64  `gorm:"column:campaign_id" json:"-"`
+	Campaign        string         `gorm:"column:campaign" json:"campaign,omitempty"`
+	TransactionTime time.Time      `gorm:"column:transactiontime" json:"time"`
 }
 
 //TransactionHistoryByID 获取交易记录 根据member.id
@@ -68,8 +117,9 @@ func TransactionHistoryByID(db *gorm.DB, mid string, start, end *time.Time, page
 	}
 	fmt.Println("time sql:", sql)
 	db1 = db.Order("transactiontime").Limit(pageSize).Offset(offset).Table("transactions t")
-	db1 = db1.Joins("JOIN members m1 ON source_id=m1.id").Joins("JOIN members m2 ON target_id=m2.id")
-	db1 = db1.Select("t.id id,order_id,m1.id member_id,m1.name mname,m1.phone phone,m2.id relation_id,m2.name rname,amount,transactiontime")
+	db1 = db1.Joins("JOIN members m1 ON source_id=m1.id").Joins("JOIN members m2 ON target_id=m2.id").Joins("LEFT JOIN adjustments ad ON ad.transaction_id=t.id")
+	db1 = db1.Joins("LEFT JOIN campaigns c ON c.id=t.campaign_id")
+	db1 = db1.Select("t.id id,order_id,m1.id member_id,m1.name mname,m1.phone phone,m2.id relation_id,m2.name rname,amount,trantype,coalesce(ad.reason,'') reason,t.wallettype wallettype,t.campaign_id campaign_id,coalesce(c.name,'') campaign,transactiontime")
 	db1 = db1.Where(sql+"amount"+greatOrLess+"0 and target_id=?", mid)
 	db1 = db1.Find(&history)
 	//db1 = db.Limit(pageSize).Offset(offset).Find(&history, "target_id=?", mid)
@@ -81,7 +131,7 @@ func TransactionHistoryByID(db *gorm.DB, mid string, start, end *time.Time, page
 	}
 	return history, nil
 }
-func (t *Transaction) fillTransaction(orderID string, sourceID string, targetID string, amount decimal.Decimal) {
+func (t *Transaction) fillTransaction(orderID string, sourceID string, targetID string, amount decimal.Decimal, tranType string) {
 	t.ID = uuid.NewV4().String()
 	if len(orderID) > 0 {
 		t.OrderID.Scan(orderID)
@@ -89,36 +139,105 @@ func (t *Transaction) fillTransaction(orderID string, sourceID string, targetID
 	t.SourceID = sourceID
 	t.TargetID = targetID
 	t.Amount = amount
+	t.TranType = tranType
 	t.TransactionTime = time.Now()
+	t.WalletType = WalletRebate
+}
+
+//tranKind 交易类型; 历史数据(trantype为空)按金额正负推断: 正数为返利, 负数为积分抵用消费
+func (t *Transaction) tranKind() string {
+	if len(t.TranType) > 0 {
+		return t.TranType
+	}
+	if t.Amount.IsNegative() {
+		return TranConsume
+	}
+	return TranRebate
 }
 
 //CreateTransactionsByLevels 根据用户祖先返回产生返利关系,交易记录
-func createTransactionsByLevels(db *gorm.DB, ul []UserLevel, amount decimal.Decimal, orderID string) []Transaction {
-	if amount.LessThanOrEqual(zero) {
-		return []Transaction{} //无需交易,返回空数组
+//	lines 返利计算行(见getRebateLines), 各级返利见levelAmounts, 返利为0的层级不产生交易
+//	交易记录的Ratio为该级返利占实付金额pay(订单payamount)的实际比例, 用于部分退款按比例扣回;
+//	排除类别的明细不计入返利基数, 但计入实付金额, 故Ratio不能按返利基数计算
+//	各级返利按RebateRounding舍入, 舍入差额在返利上限之后由rebateResidual计算;
+//	from 起始代数, 之前的层级不产生返利(充值返利不含会员自己, 为1)
+//	返回rest 舍入为0未产生交易的层级的未舍入返利合计, 计入舍入差额
+func createTransactionsByLevels(db *gorm.DB, ul []UserLevel, lines []rebateLine, pay decimal.Decimal, from int, orderID string) (ts []Transaction, rest decimal.Decimal, err error) {
+	base := zero
+	for _, l := range lines {
+		base = base.Add(l.Base)
+	}
+	if base.LessThanOrEqual(zero) {
+		return []Transaction{}, zero, nil //无需交易,返回空数组
+	}
+	es, err := levelAmounts(db, ul, lines)
+	if err != nil {
+		return nil, zero, err
 	}
-	ts := make([]Transaction, len(ul))
+	rounding := rebateRounding(db)
+	ts = make([]Transaction, 0, len(ul))
 	id := ul[0].SonID
+	rest = zero
 	//now := time.Now()
-	for i := range ts {
-		d1 := amount.Mul(levelRatios[i])
-		d1.Round(4)
+	for i, e := range es {
+		if i < from {
+			continue
+		}
+		d1 := roundAmount(e, rounding)
+		if !d1.IsPositive() {
+			rest = rest.Add(e)
+			continue
+		}
 		//fmt.Println("createTransactionsByLevels", d1, levelRatios[i])
-		ts[i].fillTransaction(orderID, id, ul[i].AncestorID, d1)
+		t := Transaction{}
+		t.fillTransaction(orderID, id, ul[i].AncestorID, d1, TranRebate)
+		t.Ratio = e.Div(pay).Round(ratioScale)
+		t.Generation = i
+		t.exact = e
+		ts = append(ts, t)
+	}
+	return ts, rest, nil
+}
+
+//levelAmounts 各级返利金额(未舍入), 各行 基数*该级分成比例 之和
+//	未匹配返利规则的行, 按该级上级会员等级(members.level)的分成比例计算, 见tierRatio
+func levelAmounts(db *gorm.DB, ul []UserLevel, lines []rebateLine) ([]decimal.Decimal, error) {
+	tiers, err := ancestorTiers(db, ul)
+	if err != nil {
+		return nil, err
+	}
+	es := make([]decimal.Decimal, len(ul))
+	for i := range ul {
+		e := zero
+		for _, l := range lines {
+			if l.Tiered {
+				e = e.Add(l.Base.Mul(tierRatio(tiers[ul[i].AncestorID], i)))
+			} else if i < len(l.Ratios) {
+				e = e.Add(l.Base.Mul(l.Ratios[i]))
+			}
+		}
+		es[i] = e
+	}
+	return es, nil
+}
+
+//reversedAmount 原交易已冲正金额合计
+func reversedAmount(db *gorm.DB, tID string) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("transactions").Select("coalesce(sum(amount),0) as sumamount").Where("reverse_id=?", tID).Scan(&a)
+	if db1.Error != nil {
+		return zero, db1.Error
 	}
-	//4位精度造成精度差的概率极低,
-	//如果做到严格准确, 可以把最后一个返利金额, 如下操作
-	//[len-1:].amount = amount * sum(levelRatios) - sum([:len-1].amount)
-	return ts
+	return a.Amount, nil
 }
 
+//saveNew 保存交易记录, 并同时过账(复式记账分录)
 func (t *Transaction) saveNew(db *gorm.DB) error {
 	db.Create(t)
 	if db.NewRecord(t) {
 		return errors.New("交易创建失败")
 	}
-	fmt.Println("done:", t)
-	return nil
+	return postTransaction(db, t)
 }
 
 //检查订单号+memberID是否重复,
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTranKind(t *testing.T) {
+	cases := []struct {
+		tranType string
+		amount   string
+		want     string
+	}{
+		{TranRebate, "1.00", TranRebate},
+		{TranClawback, "-1.00", TranClawback},
+		{TranRefund, "1.00", TranRefund},
+		{"", "1.00", TranRebate},
+		{"", "-1.00", TranConsume},
+		{"", "0", TranRebate},
+	}
+	for _, c := range cases {
+		tr := &Transaction{TranType: c.tranType, Amount: decimal.RequireFromString(c.amount)}
+		if got := tr.tranKind(); got != c.want {
+			t.Errorf("tranKind(%q, %s) = %s, want %s", c.tranType, c.amount, got, c.want)
+		}
+	}
+}
+
+func TestSortedIDs(t *testing.T) {
+	cases := []struct {
+		in, want []string
+	}{
+		{nil, []string{}},
+		{[]string{"b", "a", "c"}, []string{"a", "b", "c"}},
+		{[]string{"c", "a", "c", "b", "a"}, []string{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		if got := sortedIDs(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("sortedIDs(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+	"github.com/twinj/uuid"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//TransferEnabled 会员转赠开关配置code, 1 开启, 0 关闭
+	TransferEnabled = "TransferEnabled"
+	//TransferDailyLimit 会员每日转出上限配置code, 分为单位, <=0 不限
+	TransferDailyLimit = "TransferDailyLimit"
+)
+
+//Transfer 会员间转赠积分
+// 	from, to	转出,转入会员
+//  amountStr	金额字符串形式, 分为单位,例, 120 1块2毛
+//  order	订单号, 转出方+订单号重复检查; 空时生成, 用于关联转出转入交易
+//  keepExpiry	转入积分是否沿用转出积分中最早的过期日, 否则按PointValidDays
+//  idemKey	幂等键, 空时使用订单号
+//	return code, message
+func Transfer(db *gorm.DB, from *Member, to *Member, amountStr string, orderID string, keepExpiry bool, idemKey string) (string, string) {
+	if GetSettingInt(db, TransferEnabled, 0) != 1 {
+		return ResInvalid, "转赠功能未开启"
+	}
+	if from.ID == to.ID {
+		return ResInvalid, "不能转赠给自己"
+	}
+	amount, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return ResInvalid, err.Error()
+	}
+	if !amount.IsPositive() {
+		return ResInvalid, "转赠金额必须大于0"
+	}
+	tx := db.Begin() //开启事务
+	code, msg := transfer(tx, from, to, amount, orderID, keepExpiry, idemKey)
+	if code != ResOK {
+		tx.Rollback()
+		return code, msg
+	}
+	if db1 := tx.Commit(); db1.Error != nil {
+		return ResWrongSQL, db1.Error.Error()
+	}
+	return code, msg
+}
+
+func transfer(tx *gorm.DB, from *Member, to *Member, amount decimal.Decimal, orderID string, keepExpiry bool, idemKey string) (string, string) {
+	//按id顺序锁定双方, 避免互相转赠时死锁
+	ids := []string{from.ID, to.ID}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := lockMember(tx, id); err != nil {
+			return ResWrongSQL, err.Error()
+		}
+	}
+	if len(idemKey) == 0 {
+		idemKey = orderID
+	}
+	hash := requestHash(to.ID, amount.String(), orderID, strconv.FormatBool(keepExpiry))
+	if len(idemKey) > 0 {
+		r, err := checkIdempotency(tx, from.ID, idemKey, TranTransferOut, hash)
+		if err == ErrIdempotencyConflict {
+			return ResConflict, err.Error()
+		}
+		if err != nil {
+			return ResWrongSQL, err.Error()
+		}
+		if r != nil {
+			return ResOK, "OK"
+		}
+	}
+	validcode, err := vaildOrderID(tx, from.ID, orderID)
+	if validcode == 1 {
+		return ResDup, "order No. exist"
+	}
+	if validcode > 0 {
+		return ResFail, err.Error()
+	}
+	if len(orderID) == 0 {
+		orderID = uuid.NewV4().String()
+	}
+	if limit := GetSettingInt(tx, TransferDailyLimit, 0); limit > 0 {
+		used, err := transferredToday(tx, from.ID)
+		if err != nil {
+			return ResWrongSQL, err.Error()
+		}
+		if used.Add(amount).GreaterThan(decimal.New(int64(limit), 0)) {
+			return ResInvalid, "超过每日转赠上限" + strconv.Itoa(limit)
+		}
+	}
+
+	remind, _, out, consumedPoints := getConsumeAccount(tx, from.ID, amount, orderID, TranTransferOut)
+	if out == nil {
+		return ResWrongSQL, "积分扣减错误"
+	}
+	if remind.IsPositive() {
+		return ResInvalid, "余额不足"
+	}
+	in := Transaction{}
+	in.fillTransaction(orderID, from.ID, to.ID, amount, TranTransferIn)
+	as := getAccountPoints(tx, []Transaction{in})
+	if keepExpiry {
+		as[0].ExpireDate = nil
+		for _, a := range consumedPoints {
+			if a.Used.IsPositive() && a.ExpireDate != nil && (as[0].ExpireDate == nil || a.ExpireDate.Before(*as[0].ExpireDate)) {
+				d := *a.ExpireDate
+				as[0].ExpireDate = &d
+			}
+		}
+	}
+	if err = saveConsume(tx, nil, []Transaction{in}, as, out, consumedPoints); err != nil {
+		return ResWrongSQL, "保存错误"
+	}
+	if len(idemKey) > 0 {
+		if err = saveIdempotency(tx, from.ID, idemKey, TranTransferOut, hash, &ConsumeResult{PointUsed: NewMoney(amount)}); err != nil {
+			return ResWrongSQL, err.Error()
+		}
+	}
+	return ResOK, "OK"
+}
+
+//transferredToday 会员当日累计转出金额
+func transferredToday(db *gorm.DB, mID string) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("transactions").Select("coalesce(-sum(amount),0) as sumamount").Where("source_id=? and trantype=? and transactiontime>=current_date", mID, TranTransferOut).Scan(&a)
+	if db1.Error != nil {
+		return zero, errors.New("转赠记录查询错误: " + db1.Error.Error())
+	}
+	return a.Amount, nil
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//treeMaxNodes 下级树导出每次最多节点数
+	treeMaxNodes = 10000
+)
+
+//treeRow 下级树节点查询结果
+type treeRow struct {
+	ID          string          `gorm:"column:id"`
+	Parent      sql.NullString  `gorm:"column:reference_id"`
+	Name        sql.NullString  `gorm:"column:name"`
+	Phone       sql.NullString  `gorm:"column:phone"`
+	Level       sql.NullString  `gorm:"column:level"`
+	CreateTime  time.Time       `gorm:"column:createtime"`
+	Depth       int             `gorm:"column:depth"`
+	Path        string          `gorm:"column:path"`
+	Spend       decimal.Decimal `gorm:"column:spend"`
+	Referrals   int             `gorm:"column:referrals"`
+	Descendants int             `gorm:"column:descendants"`
+	TeamSpend   decimal.Decimal `gorm:"column:teamspend"`
+	Total       int             `gorm:"column:total"`
+}
+
+//TreeNode 下级树节点, 含统计值, Children 为本页内的直接下级
+//	Spend 累计消费(扣除退款), Referrals 直接推荐人数(不受导出深度限制)
+//	Descendants, TeamSpend 导出深度内的下级人数及其累计消费, 不受分页限制
+type TreeNode struct {
+	ID          string          `json:"id"`
+	Parent      string          `json:"parent"`
+	Name        string          `json:"name"`
+	Phone       string          `json:"phone"`
+	Level       string          `json:"level"`
+	CreateTime  string          `json:"createTime"`
+	Depth       int             `json:"depth"`
+	Path        string          `json:"path"`
+	Spend       decimal.Decimal `json:"spend"`
+	Referrals   int             `json:"referrals"`
+	Descendants int             `json:"descendants"`
+	TeamSpend   decimal.Decimal `json:"teamspend"`
+	Children    []*TreeNode     `json:"children,omitempty"`
+}
+
+//Tree 会员下级树导出结果
+//	Nodes 按深度, 路径排序的本页节点; Roots 嵌套结构, 上级不在本页的节点作为顶层节点
+//	Total 导出深度内的节点总数(含会员自己), Truncated 本页之后还有节点
+type Tree struct {
+	MemberID  string      `json:"id"`
+	Depth     int         `json:"depth"`
+	Total     int         `json:"total"`
+	Limit     int         `json:"limit"`
+	Offset    int         `json:"offset"`
+	Truncated bool        `json:"truncated"`
+	Nodes     []*TreeNode `json:"-"`
+	Roots     []*TreeNode `json:"nodes"`
+}
+
+//treeSQL 会员及导出深度内的全部下级, 按深度, 路径分页; 统计值按导出深度内全部节点计算
+//	参数: 会员id, 深度, 订单类型, 每页节点数, 偏移
+const treeSQL = `with recursive down(id,depth,path) as (
+ select id,0,array[id] from members where id=?
+ union all
+ select m.id,down.depth+1,down.path||m.id from down join members m on m.reference_id=down.id
+ where down.depth<? and not m.id=any(down.path)
+), spend as (
+ select d.id,sum(o.amount-o.refunded) amount from down d join orders o on o.member_id=d.id and o.ordertype=? group by d.id
+), team as (
+ select a.anc id,count(*) size,coalesce(sum(s.amount),0) amount
+ from (select unnest(d.path[1:d.depth]) anc,d.id from down d) a left join spend s on s.id=a.id group by a.anc
+)
+select m.id,m.reference_id,m.name,m.phone,m.level,m.createtime,d.depth,array_to_string(d.path,'/') path,
+ coalesce(s.amount,0) spend,(select count(*) from members c where c.reference_id=d.id) referrals,
+ coalesce(t.size,0) descendants,coalesce(t.amount,0) teamspend,count(*) over() total
+from down d join members m on m.id=d.id left join spend s on s.id=d.id left join team t on t.id=d.id
+order by d.depth,d.path limit ? offset ?`
+
+//ExportTree 导出会员下级树
+//	depth 导出深度(代数), <=0 不限; limit 每页节点数, <=0 或超过treeMaxNodes时为treeMaxNodes; offset 偏移
+//	节点按深度(广度优先)分页, 很宽的树可分多页导出; 会员不存在时返回sql.ErrNoRows
+func ExportTree(db *gorm.DB, mid string, depth int, limit int, offset int) (*Tree, error) {
+	if limit <= 0 || limit > treeMaxNodes {
+		limit = treeMaxNodes
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	maxDepth := depth
+	if maxDepth <= 0 {
+		maxDepth = math.MaxInt32
+	}
+	var rs []treeRow
+	db1 := db.Raw(treeSQL, mid, maxDepth, TranConsume, limit, offset).Scan(&rs)
+	if db1.Error != nil {
+		return nil, db1.Error
+	}
+	t := &Tree{MemberID: mid, Depth: depth, Limit: limit, Offset: offset, Nodes: make([]*TreeNode, len(rs)), Roots: []*TreeNode{}}
+	if len(rs) == 0 {
+		if offset == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return t, nil
+	}
+	t.Total = rs[0].Total
+	t.Truncated = offset+len(rs) < t.Total
+	byID := make(map[string]*TreeNode, len(rs))
+	for i, r := range rs {
+		n := &TreeNode{ID: r.ID, Parent: r.Parent.String, Name: r.Name.String, Phone: r.Phone.String, Level: r.Level.String,
+			CreateTime: r.CreateTime.Format("2006-01-02 15:04"), Depth: r.Depth, Path: r.Path, Spend: r.Spend,
+			Referrals: r.Referrals, Descendants: r.Descendants, TeamSpend: r.TeamSpend}
+		t.Nodes[i] = n
+		byID[n.ID] = n
+	}
+	for _, n := range t.Nodes {
+		if p, ok := byID[n.Parent]; ok && n.Depth > 0 {
+			p.Children = append(p.Children, n)
+		} else {
+			t.Roots = append(t.Roots, n)
+		}
+	}
+	return t, nil
+}
+
+//WriteTreeCSV 下级树输出csv, 每个节点一行; path0..pathN 为自会员起至该节点的各代会员id
+func WriteTreeCSV(w io.Writer, t *Tree) error {
+	maxDepth := 0
+	for _, n := range t.Nodes {
+		if n.Depth > maxDepth {
+			maxDepth = n.Depth
+		}
+	}
+	header := []string{"id", "name", "phone", "level", "depth", "parent", "spend", "referrals", "descendants", "teamspend"}
+	for i := 0; i <= maxDepth; i++ {
+		header = append(header, "path"+strconv.Itoa(i))
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, n := range t.Nodes {
+		row := []string{n.ID, n.Name, n.Phone, n.Level, strconv.Itoa(n.Depth), n.Parent,
+			n.Spend.String(), strconv.Itoa(n.Referrals), strconv.Itoa(n.Descendants), n.TeamSpend.String()}
+		path := strings.Split(n.Path, "/")
+		for i := 0; i <= maxDepth; i++ {
+			if i < len(path) {
+				row = append(row, path[i])
+			} else {
+				row = append(row, "")
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//WriteTreeDOT 下级树输出Graphviz DOT, 节点标注姓名, 等级及消费统计; 仅输出本页节点间的推荐关系
+func WriteTreeDOT(w io.Writer, t *Tree) error {
+	if _, err := fmt.Fprintf(w, "digraph tree {\n\tnode [shape=box];\n"); err != nil {
+		return err
+	}
+	for _, n := range t.Nodes {
+		label := fmt.Sprintf("%s\nlevel: %s\nspend: %s\nteam: %d / %s", n.Name, n.Level, n.Spend,
+			n.Descendants, n.TeamSpend)
+		if _, err := fmt.Fprintf(w, "\t%s [label=%s];\n", strconv.Quote(n.ID), strconv.Quote(label)); err != nil {
+			return err
+		}
+	}
+	for _, n := range t.Nodes {
+		for _, c := range n.Children {
+			if _, err := fmt.Fprintf(w, "\t%s -> %s;\n", strconv.Quote(n.ID), strconv.Quote(c.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
@@ -2,9 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"strconv"
 	"sync"
@@ -12,21 +10,25 @@ import (
 
 	gorm "gopkg.in/jinzhu/gorm.v1"
 
+	"github.com/e2u/goboot"
 	"github.com/shopspring/decimal"
 )
 
 var (
-	//levelRatios 配置分成比例
+	//levelRatios 配置分成比例, 只能由getLevelRatios读取
 	levelRatios []decimal.Decimal
 	totalRatio  decimal.Decimal
 
 	ratiosJSON string
+	//ratiosMutex 保护levelRatios, totalRatio, ratiosJSON; 与tierMutex同时持有时先取ratiosMutex
+	ratiosMutex sync.RWMutex
 )
 
 type ratiosOutput struct {
-	RespCode string   `json:"respCode"`
-	RespMsg  string   `json:"respMsg"`
-	Ratios   []string `json:"ratios"`
+	RespCode string              `json:"respCode"`
+	RespMsg  string              `json:"respMsg"`
+	Ratios   []string            `json:"ratios"`
+	Tiers    map[string][]string `json:"tiers,omitempty"`
 }
 
 //UserLevel 用户关系表
@@ -69,30 +71,40 @@ type ReferenceOutput struct {
 
 //InitLevelRatios 初始化分成比例
 func InitLevelRatios(ratios *([]decimal.Decimal)) error {
-	var mutex sync.Mutex
-	mutex.Lock()
+	ratiosMutex.Lock()
 	// if len(*ratios) <= 0 {
 	// 	return errors.New("无返利配置")
 	// }
-	levelRatios = make([]decimal.Decimal, len(*ratios))
+	setLevelRatios(*ratios)
+	ratiosMutex.Unlock()
+	return nil
+}
+
+//setLevelRatios 替换分成比例并重新生成json, 调用方须持有ratiosMutex写锁
+func setLevelRatios(ratios []decimal.Decimal) {
+	levelRatios = make([]decimal.Decimal, len(ratios))
 	totalRatio = zero
-	str := make([]string, len(levelRatios))
-	for i, d := range *ratios {
+	for i, d := range ratios {
 		levelRatios[i] = d
 		totalRatio.Add(d)
-		str[i] = d.String()
 	}
-	b, _ := json.Marshal(ratiosOutput{"200", "OK", str})
-	ratiosJSON = string(b)
-	mutex.Unlock()
-	return nil
+	buildRatiosJSON()
 }
 
-func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, updateAll bool) error {
+//getLevelRatios 当前分成比例的副本, 读取分成比例均须通过此函数
+func getLevelRatios() []decimal.Decimal {
+	ratiosMutex.RLock()
+	defer ratiosMutex.RUnlock()
+	rs := make([]decimal.Decimal, len(levelRatios))
+	copy(rs, levelRatios)
+	return rs
+}
+
+func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, newRatios []decimal.Decimal, updateAll bool) error {
 	log.Println("开始更新用户关系表")
-	//fmt.Println(oldRatios, levelRatios)
+	//fmt.Println(oldRatios, newRatios)
 	var where, value []string
-	newLen := len(levelRatios)
+	newLen := len(newRatios)
 	oldLen := len(oldRatios)
 	//var deleFrom, deleTo, insertFrom,insertTo
 	// insertTo = deleFrom = newLen
@@ -111,7 +123,7 @@ func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, u
 	//generate update condition & value to be set
 	for i := 0; i < updTo; i++ {
 		if /*i < oldLen &&*/ mask[i] {
-			value = append(value, levelRatios[i].String())
+			value = append(value, newRatios[i].String())
 			str := "generations=" + strconv.Itoa(i)
 			if !updateAll /*&& i < oldLen*/ {
 				str += " and royaltyratio=" + oldRatios[i].String()
@@ -137,7 +149,7 @@ func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, u
 	var from int
 	if oldLen == 0 {
 		from = 1
-		db1 = tx.Exec("insert into user_levels(sonnode_id,ancestornode_id,royaltyratio,generations,updtime) select id, id,?,0,? from members where reference_id is not null;", levelRatios[0], now)
+		db1 = tx.Exec("insert into user_levels(sonnode_id,ancestornode_id,royaltyratio,generations,updtime) select id, id,?,0,? from members where reference_id is not null;", newRatios[0], now)
 		if db1.Error != nil {
 			tx.Rollback()
 			return db1.Error
@@ -146,7 +158,7 @@ func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, u
 		from = oldLen
 	}
 	for i := from; i < newLen; i++ {
-		db1 = tx.Exec("insert into user_levels(sonnode_id,ancestornode_id,royaltyratio,generations,updtime) select sonnode_id, m.reference_id,?,?,? from user_levels,members m where m.id=ancestornode_id and generations=? and reference_id is not null;", levelRatios[i], i, now, i-1)
+		db1 = tx.Exec("insert into user_levels(sonnode_id,ancestornode_id,royaltyratio,generations,updtime) select sonnode_id, m.reference_id,?,?,? from user_levels,members m where m.id=ancestornode_id and generations=? and reference_id is not null;", newRatios[i], i, now, i-1)
 		if db1.Error != nil {
 			tx.Rollback()
 			return db1.Error
@@ -167,6 +179,8 @@ func updateAllUserLevel(db *gorm.DB, mask []bool, oldRatios []decimal.Decimal, u
 
 //GetRatioJSON 获取费率json
 func GetRatioJSON() string {
+	ratiosMutex.RLock()
+	defer ratiosMutex.RUnlock()
 	return ratiosJSON
 }
 
@@ -175,7 +189,7 @@ func GetRatioJSON() string {
 //isUpdate = 1, 跳过 自己记录, 更新绑定
 func CreateLevels(db *gorm.DB, member *Member, isUpdate int) (*UserLevel, error) {
 	u := &UserLevel{}
-	l := len(levelRatios)
+	l := len(getLevelRatios())
 	length := l
 	if l <= 0 {
 		return nil, errors.New("返利配置错误")
@@ -258,19 +272,38 @@ func MapReference2Output(rs []ReferenceRelationship) []ReferenceOutput {
 	return ros
 }
 
-// 是否mid祖先中包含 ref
-// return nil,nil 不包含
-// to do 未完全完成,仅检查了最近3代,没做完全检查
+//uplineSQL 由members.reference_id逐级向上查找全部上级(不限层数), 第1代为直接推荐人
+//	path 记录已经过的会员, 数据异常存在循环时终止
+const uplineSQL = `with recursive up(id,generations,path) as (
+ select reference_id,1,array[id,reference_id] from members where id=? and reference_id is not null
+ union all
+ select m.reference_id,up.generations+1,up.path||m.reference_id from up join members m on m.id=up.id
+ where m.reference_id is not null and not m.reference_id=any(up.path))`
+
+//checkAncestor 是否mid祖先中包含 ref(或mid即为ref), 按members.reference_id检查全部上级
 func checkAncestor(db *gorm.DB, mid string, ref string) (bool, error) {
-	ul := UserLevel{}
-	db1 := db.Where("sonnode_id=? and ancestornode_id=?", mid, ref).First(&ul)
-	if db1.RecordNotFound() {
-		return false, nil
+	if mid == ref {
+		return true, nil
 	}
+	var n int
+	if err := db.Raw(uplineSQL+" select count(*) from up where id=?", mid, ref).Row().Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+//FindAncestorsByID 会员全部上级, 按代数, 不受返利层数限制
+//	royaltyratio 为用户关系表内的分成比例, 超出返利层数的上级为0
+func FindAncestorsByID(db *gorm.DB, id string) ([]ReferenceRelationship, error) {
+	var refs []ReferenceRelationship
+	db1 := db.Raw(uplineSQL+` select m.*,coalesce(ul.royaltyratio,0) royaltyratio,up.generations from up
+ join members m on m.id=up.id
+ left join user_levels ul on ul.sonnode_id=? and ul.ancestornode_id=up.id and ul.generations=up.generations
+ order by up.generations`, id, id).Scan(&refs)
 	if db1.Error != nil {
-		return false, db1.Error
+		return nil, db1.Error
 	}
-	return true, nil
+	return refs, nil
 }
 
 //fillNewUserLevel 用输入字段创建 user level 对象
@@ -278,7 +311,9 @@ func (u *UserLevel) fillNewUserLevel(son string, ancestor string, generations in
 	u.ID = 0 //自增, 清除
 	u.SonID = son
 	u.AncestorID = ancestor
-	u.RoyaltyRatio = levelRatios[generations]
+	if rs := getLevelRatios(); generations < len(rs) {
+		u.RoyaltyRatio = rs[generations]
+	}
 	u.Generations = generations
 	u.UpdTime = time.Now()
 }
@@ -286,9 +321,9 @@ func (u *UserLevel) fillNewUserLevel(son string, ancestor string, generations in
 //GetLevelsByMember 获取用户
 func getLevelsByMember(db *gorm.DB, mid string) ([]UserLevel, error) {
 	var ul []UserLevel
-	db1 := db.Order("generations").Limit(len(levelRatios)).Find(&ul, "sonnode_id=?", mid)
+	db1 := db.Order("generations").Limit(len(getLevelRatios())).Find(&ul, "sonnode_id=?", mid)
 	if db1.Error != nil {
-		fmt.Println(db1.Error)
+		goboot.Log.Error(db1.Error)
 	} else { //校验返回结果 有序, 连续
 		for i, u := range ul {
 			if u.Generations != i {
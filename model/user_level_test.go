@@ -0,0 +1,21 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGetLevelRatiosCopy(t *testing.T) {
+	old := levelRatios
+	defer func() { levelRatios = old }()
+	levelRatios = decimals("0.1", "0.05")
+	rs := getLevelRatios()
+	rs[0] = decimal.New(9, 0)
+	if !levelRatios[0].Equal(decimal.RequireFromString("0.1")) {
+		t.Errorf("getLevelRatios returned shared slice, levelRatios[0] = %s", levelRatios[0])
+	}
+	if !equalDecimals(getLevelRatios(), decimals("0.1", "0.05")) {
+		t.Errorf("getLevelRatios() = %v", getLevelRatios())
+	}
+}
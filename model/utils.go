@@ -20,8 +20,12 @@ const (
 	ResInvalid = "412"
 	//ResPhoneInvalid 无效手机号
 	ResPhoneInvalid = "4121"
+	//ResUnauthorized 无管理权限
+	ResUnauthorized = "401"
 	//ResNotFound 没有对应记录
 	ResNotFound = "404"
+	//ResConflict 幂等键重复, 请求内容不一致
+	ResConflict = "409"
 	//ResFound 成功找到
 	ResFound = "200"
 	//ResWrongSQL 查询语句错误
@@ -30,9 +34,6 @@ const (
 	ResFail = "500"
 	//ResFailCreateMember 创建用户异常
 	ResFailCreateMember = "501"
-
-	//到账期限, T+n n=AvailableDays
-	AvailableDays = 0
 )
 
 var (
@@ -42,6 +43,7 @@ var (
 //Init 初始化 分级分成比例
 func Init(db *gorm.DB, ratios *([]decimal.Decimal)) {
 	InitLevelRatios(ratios)
+	InitTierRatios(db)
 	InitCardNo(db)
 
 }
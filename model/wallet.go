@@ -0,0 +1,142 @@
+package model
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+
+	gorm "gopkg.in/jinzhu/gorm.v1"
+)
+
+const (
+	//WalletRebate 钱包类型: 返利积分
+	WalletRebate = "rebate"
+	//WalletStored 钱包类型: 储值
+	WalletStored = "stored"
+	//WalletPromo 钱包类型: 促销赠送积分
+	WalletPromo = "promo"
+	//WalletMixed 交易扣减了多种钱包, 仅用于交易记录, 明细见account_usages
+	WalletMixed = "mixed"
+
+	//AvailableDays 返利到账期限配置code, T+n, 期间为待到账(不可抵用,提现,转赠), 退款时直接取消; <=0 即时到账
+	//	按钱包类型配置code为 AvailableDays.钱包类型, 例 AvailableDays.rebate, 无配置时按全局配置
+	AvailableDays = "AvailableDays"
+)
+
+//WalletRule 钱包规则
+type WalletRule struct {
+	//Cashout 可提现(含转赠)
+	Cashout bool
+	//Pay 可抵用消费
+	Pay bool
+	//Clawback 可扣回返利; 储值为会员付款所得, 不用于扣回返利
+	Clawback bool
+	//Order 消费扣减顺序, 小的优先
+	Order int
+}
+
+var (
+	//walletRules 各钱包类型规则
+	walletRules = map[string]WalletRule{
+		WalletPromo:  {Cashout: false, Pay: true, Clawback: true, Order: 1},
+		WalletRebate: {Cashout: true, Pay: true, Clawback: true, Order: 2},
+		WalletStored: {Cashout: false, Pay: true, Clawback: false, Order: 3},
+	}
+)
+
+//WalletBalance 钱包余额
+type WalletBalance struct {
+	Wallet string `gorm:"column:wallettype" json:"wallet"`
+	Amount Money  `gorm:"column:sumamount" json:"amount"`
+	//Pending 待到账金额, 到账期限内的返利
+	Pending Money `gorm:"column:pending" json:"pending"`
+}
+
+//ValidWallet 是否有效钱包类型
+func ValidWallet(w string) bool {
+	_, ok := walletRules[w]
+	return ok
+}
+
+//walletsFor 交易类型可扣减的钱包, 按扣减顺序
+//	提现,转赠仅扣减可提现钱包; 抵用消费仅扣减可抵用钱包; 扣回返利仅扣减可扣回钱包, 不足部分记为欠款; 调整扣减全部钱包
+func walletsFor(tranType string) []string {
+	ws := make([]string, 0, len(walletRules))
+	for w, r := range walletRules {
+		switch tranType {
+		case TranCashout, TranTransferOut:
+			if !r.Cashout {
+				continue
+			}
+		case TranConsume:
+			if !r.Pay {
+				continue
+			}
+		case TranClawback:
+			if !r.Clawback {
+				continue
+			}
+		}
+		ws = append(ws, w)
+	}
+	sort.Slice(ws, func(i, j int) bool { return walletRules[ws[i]].Order < walletRules[ws[j]].Order })
+	return ws
+}
+
+//walletOf 扣减记录对应的交易钱包类型, 涉及多种钱包时为WalletMixed
+func walletOf(as []Account) string {
+	w := ""
+	for _, a := range as {
+		if len(w) == 0 {
+			w = a.WalletType
+		} else if w != a.WalletType {
+			return WalletMixed
+		}
+	}
+	if len(w) == 0 {
+		return WalletRebate
+	}
+	return w
+}
+
+//getAmountByWallets 指定钱包的有效余额, 扣除全部欠款(负数记录)
+func getAmountByWallets(db *gorm.DB, mID string, wallets []string) (decimal.Decimal, error) {
+	a := AccountPoint{}
+	db1 := db.Table("accounts").Select("member_id,sum(amount) as sumamount")
+	db1 = db1.Where("((current_date>=startdate and ((current_date<=expiredate) or (expiredate is null)) and amount>0 and wallettype in (?)) or amount<0) and member_id=?", wallets, mID)
+	db1 = db1.Group("member_id").First(&a)
+	if db1.RecordNotFound() {
+		return zero, nil
+	}
+	if db1.Error != nil {
+		return zero, db1.Error
+	}
+	return a.Amount, nil
+}
+
+//availableDays 钱包的返利到账天数, 钱包无配置时按全局配置
+func availableDays(db *gorm.DB, wallet string) int {
+	return GetSettingInt(db, AvailableDays+"."+wallet, GetSettingInt(db, AvailableDays, 0))
+}
+
+//GetWalletBalances 会员各钱包有效余额(含欠款)及待到账金额, 按扣减顺序
+func GetWalletBalances(db *gorm.DB, mID string) ([]WalletBalance, error) {
+	var bs []WalletBalance
+	db1 := db.Table("accounts").Select("wallettype,sum(case when current_date>=startdate or amount<0 then amount else 0 end) as sumamount," +
+		"sum(case when current_date<startdate and amount>0 then amount else 0 end) as pending")
+	db1 = db1.Where("((((current_date<=expiredate) or (expiredate is null)) and amount>0) or amount<0) and member_id=?", mID)
+	if db1 = db1.Group("wallettype").Scan(&bs); db1.Error != nil {
+		return nil, db1.Error
+	}
+	sort.Slice(bs, func(i, j int) bool { return walletRules[bs[i].Wallet].Order < walletRules[bs[j].Wallet].Order })
+	return bs, nil
+}
+
+//PendingTotal 各钱包待到账金额合计
+func PendingTotal(bs []WalletBalance) Money {
+	total := zero
+	for _, b := range bs {
+		total = total.Add(b.Pending.Amount)
+	}
+	return NewMoney(total)
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWalletsFor(t *testing.T) {
+	cases := []struct {
+		tranType string
+		want     []string
+	}{
+		{TranCashout, []string{WalletRebate}},
+		{TranTransferOut, []string{WalletRebate}},
+		{TranConsume, []string{WalletPromo, WalletRebate, WalletStored}},
+		{TranClawback, []string{WalletPromo, WalletRebate}},
+		{TranAdjust, []string{WalletPromo, WalletRebate, WalletStored}},
+	}
+	for _, c := range cases {
+		if got := walletsFor(c.tranType); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("walletsFor(%s) = %v, want %v", c.tranType, got, c.want)
+		}
+	}
+}
@@ -104,7 +104,9 @@ CREATE TABLE accounts (
     startdate date NOT NULL,
     getdate date NOT NULL,
     getamount numeric(11,2) NOT NULL,
-    updtime timestamp without time zone NOT NULL
+    updtime timestamp without time zone NOT NULL,
+    transaction_id uuid,
+    wallettype text DEFAULT 'rebate'::text NOT NULL
 );
 
 
@@ -128,6 +130,13 @@ COMMENT ON COLUMN accounts.amount IS '当前余额';
 COMMENT ON COLUMN accounts.expiredate IS '过期时间';
 
 
+--
+-- Name: COLUMN accounts.wallettype; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN accounts.wallettype IS '钱包类型: rebate返利积分(可提现), stored储值, promo促销赠送';
+
+
 --
 -- TOC entry 2265 (class 0 OID 0)
 -- Dependencies: 172
@@ -155,6 +164,33 @@ COMMENT ON COLUMN accounts.getdate IS '获取时间';
 COMMENT ON COLUMN accounts.getamount IS '初始金额';
 
 
+--
+-- Name: COLUMN accounts.transaction_id; Type: COMMENT; Schema: public; Owner: ydy
+--
+
+COMMENT ON COLUMN accounts.transaction_id IS '产生该记录的交易';
+
+
+--
+-- Name: account_usages; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE account_usages (
+    id uuid NOT NULL,
+    transaction_id uuid NOT NULL,
+    account_id uuid NOT NULL,
+    amount numeric(11,2) NOT NULL,
+    updtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE account_usages; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE account_usages IS '交易扣减账户记录明细,冲正时按明细恢复';
+
+
 --
 -- TOC entry 176 (class 1259 OID 175663)
 -- Name: members; Type: TABLE; Schema: public; Owner: -
@@ -236,7 +272,13 @@ CREATE TABLE transactions (
     source_id uuid NOT NULL,
     target_id uuid NOT NULL,
     amount numeric(11,2) NOT NULL,
-    transactiontime timestamp without time zone NOT NULL
+    trantype text DEFAULT ''::text NOT NULL,
+    transactiontime timestamp without time zone NOT NULL,
+    ratio numeric(5,4) DEFAULT 0 NOT NULL,
+    reverse_id uuid,
+    wallettype text DEFAULT 'rebate'::text NOT NULL,
+    campaign_id integer,
+    account_id uuid
 );
 
 
@@ -250,11 +292,175 @@ COMMENT ON TABLE transactions IS '交易流水,获取金额或消费金额';
 
 
 --
--- TOC entry 177 (class 1259 OID 175669)
--- Name: user_levels_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+-- Name: COLUMN transactions.ratio; Type: COMMENT; Schema: public; Owner: -
 --
 
-CREATE SEQUENCE user_levels_id_seq
+COMMENT ON COLUMN transactions.ratio IS '返利交易对应的分成比例';
+
+
+--
+-- Name: COLUMN transactions.reverse_id; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN transactions.reverse_id IS '冲正交易对应的原交易';
+
+
+--
+-- Name: COLUMN transactions.wallettype; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN transactions.wallettype IS '钱包类型: rebate返利积分, stored储值, promo促销赠送; 扣减多种钱包时为mixed, 明细见account_usages';
+
+
+--
+-- Name: COLUMN transactions.campaign_id; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN transactions.campaign_id IS '产生该返利(及其扣回)的促销活动, 常规返利为空';
+
+
+--
+-- Name: COLUMN transactions.account_id; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN transactions.account_id IS '过期交易对应的账户记录, 其他交易为空';
+
+
+--
+-- Name: COLUMN transactions.trantype; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN transactions.trantype IS '交易类型: rebate返利, consume消费, cashout提现, expire过期, clawback退款扣回返利, refund退款退还积分, adjust调整, transfer_out转赠转出, transfer_in转赠转入';
+
+
+--
+-- Name: orders; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE orders (
+    id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    orderno text,
+    ordertype text NOT NULL,
+    amount numeric(11,2) NOT NULL,
+    pointused numeric(11,2) DEFAULT 0 NOT NULL,
+    payamount numeric(11,2) DEFAULT 0 NOT NULL,
+    refunded numeric(11,2) DEFAULT 0 NOT NULL,
+    createtime timestamp without time zone NOT NULL,
+    updtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE orders; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE orders IS '订单记录,退款比例计算及累计退款';
+
+
+--
+-- Name: COLUMN orders.payamount; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN orders.payamount IS '实付金额,返利计算基数';
+
+
+--
+-- Name: COLUMN orders.refunded; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN orders.refunded IS '累计退款金额,不超过amount';
+
+
+--
+-- Name: adjustments; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE adjustments (
+    id uuid NOT NULL,
+    transaction_id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    reason text NOT NULL,
+    operator text NOT NULL,
+    remark text,
+    createtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE adjustments; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE adjustments IS '人工调整记录';
+
+
+--
+-- Name: COLUMN adjustments.reason; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN adjustments.reason IS '调整原因代码: compensation客诉补偿, correction差错更正, goodwill关怀赠送, fraud违规扣除, other其他';
+
+
+--
+-- Name: cashout_requests; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE cashout_requests (
+    id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    orderno text NOT NULL,
+    amount numeric NOT NULL,
+    fee numeric DEFAULT 0 NOT NULL,
+    status text NOT NULL,
+    transaction_id uuid NOT NULL,
+    fee_transaction_id uuid,
+    operator text NOT NULL,
+    createtime timestamp without time zone NOT NULL,
+    updtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE cashout_requests; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE cashout_requests IS '提现申请';
+
+
+--
+-- Name: COLUMN cashout_requests.status; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN cashout_requests.status IS '状态: requested已申请, approved已审批, rejected已拒绝, paid已打款, failed打款失败';
+
+
+--
+-- Name: cashout_logs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE cashout_logs (
+    id uuid NOT NULL,
+    request_id uuid NOT NULL,
+    fromstatus text NOT NULL,
+    tostatus text NOT NULL,
+    operator text NOT NULL,
+    remark text,
+    createtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE cashout_logs; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE cashout_logs IS '提现申请状态变更记录';
+
+
+--
+-- Name: cashout_policies_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE cashout_policies_id_seq
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
@@ -263,171 +469,914 @@ CREATE SEQUENCE user_levels_id_seq
 
 
 --
--- TOC entry 178 (class 1259 OID 175671)
--- Name: user_levels; Type: TABLE; Schema: public; Owner: -
+-- Name: cashout_policies; Type: TABLE; Schema: public; Owner: -
 --
 
-CREATE TABLE user_levels (
-    id integer DEFAULT nextval('user_levels_id_seq'::regclass) NOT NULL,
-    sonnode_id uuid NOT NULL,
-    ancestornode_id uuid NOT NULL,
-    royaltyratio numeric(5,4) DEFAULT 0 NOT NULL,
-    generations integer NOT NULL,
-    updtime timestamp without time zone NOT NULL
+CREATE TABLE cashout_policies (
+    id integer DEFAULT nextval('cashout_policies_id_seq'::regclass) NOT NULL,
+    level text,
+    minamount numeric DEFAULT 0 NOT NULL,
+    maxamount numeric DEFAULT 0 NOT NULL,
+    dailycap numeric DEFAULT 0 NOT NULL,
+    monthlycap numeric DEFAULT 0 NOT NULL,
+    feeflat numeric DEFAULT 0 NOT NULL,
+    feerate numeric DEFAULT 0 NOT NULL
 );
 
+
 --
--- TOC entry 2272 (class 0 OID 0)
--- Dependencies: 178
--- Name: COLUMN user_levels.sonnode_id; Type: COMMENT; Schema: public; Owner: ydy
+-- Name: TABLE cashout_policies; Type: COMMENT; Schema: public; Owner: -
 --
 
-COMMENT ON COLUMN user_levels.sonnode_id IS '子节点';
+COMMENT ON TABLE cashout_policies IS '提现规则, level为空为全局规则, 否则覆盖对应会员等级; 金额为0不限';
 
 
 --
--- TOC entry 2273 (class 0 OID 0)
--- Dependencies: 178
--- Name: COLUMN user_levels.ancestornode_id; Type: COMMENT; Schema: public; Owner: ydy
+-- Name: rebate_rules_id_seq; Type: SEQUENCE; Schema: public; Owner: -
 --
 
-COMMENT ON COLUMN user_levels.ancestornode_id IS '祖辈节点';
+CREATE SEQUENCE rebate_rules_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
 --
--- TOC entry 2274 (class 0 OID 0)
--- Dependencies: 178
--- Name: COLUMN user_levels.royaltyratio; Type: COMMENT; Schema: public; Owner: ydy
+-- Name: rebate_rules; Type: TABLE; Schema: public; Owner: -
 --
 
-COMMENT ON COLUMN user_levels.royaltyratio IS '分成比例';
+CREATE TABLE rebate_rules (
+    id integer DEFAULT nextval('rebate_rules_id_seq'::regclass) NOT NULL,
+    merchant text,
+    category text,
+    ratios text DEFAULT ''::text NOT NULL,
+    excluded boolean DEFAULT false NOT NULL
+);
 
 
 --
--- TOC entry 2275 (class 0 OID 0)
--- Dependencies: 178
--- Name: COLUMN user_levels.generations; Type: COMMENT; Schema: public; Owner: ydy
+-- Name: TABLE rebate_rules; Type: COMMENT; Schema: public; Owner: -
 --
 
-COMMENT ON COLUMN user_levels.generations IS '代数 差';
+COMMENT ON TABLE rebate_rules IS '返利规则, 按商户及商品类别匹配分成比例; 优先级: 商户+类别 > 类别 > 商户 > 全局分成比例';
 
 
 --
--- TOC entry 2247 (class 0 OID 175647)
--- Dependencies: 172
--- Data for Name: accounts; Type: TABLE DATA; Schema: public; Owner: -
+-- Name: COLUMN rebate_rules.ratios; Type: COMMENT; Schema: public; Owner: -
 --
 
+COMMENT ON COLUMN rebate_rules.ratios IS '逗号分隔的各级分成比例, 例 0.1,0.05,0.03';
 
 
 --
--- TOC entry 2251 (class 0 OID 175663)
--- Dependencies: 176
--- Data for Name: members; Type: TABLE DATA; Schema: public; Owner: -
+-- Name: tier_ratios; Type: TABLE; Schema: public; Owner: -
 --
 
+CREATE TABLE tier_ratios (
+    level integer NOT NULL,
+    generation integer NOT NULL,
+    ratio numeric(5,4) NOT NULL,
+    updtime timestamp without time zone DEFAULT now() NOT NULL
+);
 
 
 --
--- TOC entry 2249 (class 0 OID 175653)
--- Dependencies: 174
--- Data for Name: system_settings; Type: TABLE DATA; Schema: public; Owner: -
+-- Name: TABLE tier_ratios; Type: COMMENT; Schema: public; Owner: -
 --
 
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (1, 'levels', '3', '提成层数', '2017-06-06 09:50:27.641084');
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (2, 'level0ratio', '0.1', '第0层分成比例', '2017-06-06 09:52:01');
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (3, 'level1ratio', '0.05', '第1层分成比例', '2017-06-06 09:51:00');
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (4, 'level2ratio', '0.03', '第2层分成比例', '2017-06-06 09:51:01');
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (5, 'level3ratio', '0.02', '第3层分成比例', '2017-06-06 09:52:01');
-INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (6, 'NewUsereBonus', '500', '单位人民币分', '2017-06-06 09:52:01');
+COMMENT ON TABLE tier_ratios IS '会员等级分成比例, 等级(members.level) x 代数; 上级会员等级无配置时按全局分成比例';
 
 
 --
--- TOC entry 2267 (class 0 OID 0)
--- Dependencies: 173
--- Name: systemsettings_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
+-- Name: tier_rules; Type: TABLE; Schema: public; Owner: -
 --
 
-SELECT pg_catalog.setval('systemsettings_id_seq', 4, true);
+CREATE TABLE tier_rules (
+    level integer NOT NULL,
+    minspend numeric DEFAULT 0 NOT NULL,
+    minreferrals integer DEFAULT 0 NOT NULL,
+    minteamvolume numeric DEFAULT 0 NOT NULL
+);
 
 
 --
--- TOC entry 2250 (class 0 OID 175660)
--- Dependencies: 175
--- Data for Name: transactions; Type: TABLE DATA; Schema: public; Owner: -
+-- Name: TABLE tier_rules; Type: COMMENT; Schema: public; Owner: -
 --
 
+COMMENT ON TABLE tier_rules IS '会员等级评定规则, 同时满足全部条件的最高等级为会员等级; 金额为分, 统计天数见TierWindowDays';
 
 
 --
--- TOC entry 2253 (class 0 OID 175671)
--- Dependencies: 178
--- Data for Name: user_levels; Type: TABLE DATA; Schema: public; Owner: -
+-- Name: tier_changes_id_seq; Type: SEQUENCE; Schema: public; Owner: -
 --
 
+CREATE SEQUENCE tier_changes_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
 --
--- TOC entry 2268 (class 0 OID 0)
--- Dependencies: 177
--- Name: user_levels_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
+-- Name: tier_changes; Type: TABLE; Schema: public; Owner: -
 --
 
-SELECT pg_catalog.setval('user_levels_id_seq', 1, false);
+CREATE TABLE tier_changes (
+    id integer DEFAULT nextval('tier_changes_id_seq'::regclass) NOT NULL,
+    member_id uuid NOT NULL,
+    fromlevel integer,
+    tolevel integer,
+    spend numeric DEFAULT 0 NOT NULL,
+    referrals integer DEFAULT 0 NOT NULL,
+    teamvolume numeric DEFAULT 0 NOT NULL,
+    reason text NOT NULL,
+    createtime timestamp without time zone NOT NULL
+);
 
 
 --
--- TOC entry 2125 (class 2606 OID 175713)
--- Name: accounts_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: TABLE tier_changes; Type: COMMENT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY accounts
-    ADD CONSTRAINT accounts_pkey PRIMARY KEY (id);
+COMMENT ON TABLE tier_changes IS '会员等级变更记录, 含评定时的统计值';
 
 
 --
--- TOC entry 2131 (class 2606 OID 175677)
--- Name: members_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: campaigns_id_seq; Type: SEQUENCE; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY members
-    ADD CONSTRAINT members_pkey PRIMARY KEY (id);
+CREATE SEQUENCE campaigns_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
 --
--- TOC entry 2127 (class 2606 OID 175715)
--- Name: system_settings_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: campaigns; Type: TABLE; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY system_settings
-    ADD CONSTRAINT system_settings_pkey PRIMARY KEY (id);
+CREATE TABLE campaigns (
+    id integer DEFAULT nextval('campaigns_id_seq'::regclass) NOT NULL,
+    name text NOT NULL,
+    starttime timestamp without time zone NOT NULL,
+    endtime timestamp without time zone NOT NULL,
+    level integer,
+    branch text,
+    newmember boolean DEFAULT false NOT NULL,
+    multiplier numeric DEFAULT 0 NOT NULL,
+    ratios text DEFAULT ''::text NOT NULL
+);
 
 
 --
--- TOC entry 2129 (class 2606 OID 175709)
--- Name: transactions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: TABLE campaigns; Type: COMMENT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY transactions
-    ADD CONSTRAINT transactions_pkey PRIMARY KEY (id);
+COMMENT ON TABLE campaigns IS '促销活动, 活动期内符合条件(会员等级, 消费商户branch, 首单)的消费在常规返利之外产生活动返利; multiplier返利倍数与ratios替换分成比例二选一';
 
 
 --
--- TOC entry 2133 (class 2606 OID 175711)
--- Name: user_levels_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: recharges; Type: TABLE; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY user_levels
-    ADD CONSTRAINT user_levels_pkey PRIMARY KEY (id);
+CREATE TABLE recharges (
+    id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    channel text NOT NULL,
+    externalref text NOT NULL,
+    amount numeric(11,2) NOT NULL,
+    bonus numeric(11,2) DEFAULT 0 NOT NULL,
+    rebate numeric(11,2) DEFAULT 0 NOT NULL,
+    transaction_id uuid NOT NULL,
+    createtime timestamp without time zone NOT NULL
+);
 
 
 --
--- TOC entry 2134 (class 2606 OID 175693)
--- Name: accounts_member_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: TABLE recharges; Type: COMMENT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY accounts
-    ADD CONSTRAINT accounts_member_id_fkey FOREIGN KEY (member_id) REFERENCES members(id);
+COMMENT ON TABLE recharges IS '渠道充值记录, 渠道+流水号唯一; amount计入储值钱包, bonus赠送计入促销钱包, rebate上级返利合计';
+
+
+--
+-- Name: recharge_tiers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE recharge_tiers (
+    minamount numeric(11,2) NOT NULL,
+    bonus numeric(11,2) NOT NULL
+);
+
+
+--
+-- Name: TABLE recharge_tiers; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE recharge_tiers IS '充值赠送档位, 充值金额不低于minamount的最高档位赠送bonus, 单位人民币分';
+
+
+--
+-- Name: reference_changes_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE reference_changes_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: reference_changes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE reference_changes (
+    id integer DEFAULT nextval('reference_changes_id_seq'::regclass) NOT NULL,
+    member_id uuid NOT NULL,
+    oldreference_id uuid,
+    newreference_id uuid,
+    action text NOT NULL,
+    reason text DEFAULT ''::text NOT NULL,
+    descendants integer DEFAULT 0 NOT NULL,
+    recomputed boolean DEFAULT false NOT NULL,
+    clawback numeric DEFAULT 0 NOT NULL,
+    reissued numeric DEFAULT 0 NOT NULL,
+    rebindafter timestamp without time zone,
+    createtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE reference_changes; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE reference_changes IS '推荐关系变更记录(调整推荐人, 解绑), 含一并移动的下级人数, 转移的历史返利及解绑冷静期';
+
+
+--
+-- Name: idempotency_keys; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE idempotency_keys (
+    member_id uuid NOT NULL,
+    idemkey text NOT NULL,
+    operation text NOT NULL,
+    requesthash text NOT NULL,
+    response text NOT NULL,
+    createtime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE idempotency_keys; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE idempotency_keys IS '幂等键,会员+幂等键唯一,重复请求重放原结果';
+
+
+--
+-- Name: ledger_accounts; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE ledger_accounts (
+    code text NOT NULL,
+    name text NOT NULL,
+    category text NOT NULL
+);
+
+
+--
+-- Name: TABLE ledger_accounts; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE ledger_accounts IS '会计科目';
+
+
+--
+-- Name: COLUMN ledger_accounts.category; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN ledger_accounts.category IS '科目类别: asset资产, liability负债, income收入, expense费用';
+
+
+--
+-- Name: journal_entries; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE journal_entries (
+    id uuid NOT NULL,
+    transaction_id uuid,
+    trantype text NOT NULL,
+    entrytime timestamp without time zone NOT NULL
+);
+
+
+--
+-- Name: TABLE journal_entries; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE journal_entries IS '会计分录,每笔交易记录对应一笔分录';
+
+
+--
+-- Name: postings; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE postings (
+    id uuid NOT NULL,
+    entry_id uuid NOT NULL,
+    account text NOT NULL,
+    member_id uuid,
+    amount numeric(11,2) NOT NULL
+);
+
+
+--
+-- Name: TABLE postings; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON TABLE postings IS '分录明细,借方为正,贷方为负,同一分录合计为0';
+
+
+--
+-- Name: COLUMN postings.member_id; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN postings.member_id IS '会员钱包科目分户';
+
+
+--
+-- TOC entry 177 (class 1259 OID 175669)
+-- Name: user_levels_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE user_levels_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- TOC entry 178 (class 1259 OID 175671)
+-- Name: user_levels; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE user_levels (
+    id integer DEFAULT nextval('user_levels_id_seq'::regclass) NOT NULL,
+    sonnode_id uuid NOT NULL,
+    ancestornode_id uuid NOT NULL,
+    royaltyratio numeric(5,4) DEFAULT 0 NOT NULL,
+    generations integer NOT NULL,
+    updtime timestamp without time zone NOT NULL
+);
+
+--
+-- TOC entry 2272 (class 0 OID 0)
+-- Dependencies: 178
+-- Name: COLUMN user_levels.sonnode_id; Type: COMMENT; Schema: public; Owner: ydy
+--
+
+COMMENT ON COLUMN user_levels.sonnode_id IS '子节点';
+
+
+--
+-- TOC entry 2273 (class 0 OID 0)
+-- Dependencies: 178
+-- Name: COLUMN user_levels.ancestornode_id; Type: COMMENT; Schema: public; Owner: ydy
+--
+
+COMMENT ON COLUMN user_levels.ancestornode_id IS '祖辈节点';
+
+
+--
+-- TOC entry 2274 (class 0 OID 0)
+-- Dependencies: 178
+-- Name: COLUMN user_levels.royaltyratio; Type: COMMENT; Schema: public; Owner: ydy
+--
+
+COMMENT ON COLUMN user_levels.royaltyratio IS '分成比例';
+
+
+--
+-- TOC entry 2275 (class 0 OID 0)
+-- Dependencies: 178
+-- Name: COLUMN user_levels.generations; Type: COMMENT; Schema: public; Owner: ydy
+--
+
+COMMENT ON COLUMN user_levels.generations IS '代数 差';
+
+
+--
+-- TOC entry 2247 (class 0 OID 175647)
+-- Dependencies: 172
+-- Data for Name: accounts; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+
+
+--
+-- TOC entry 2251 (class 0 OID 175663)
+-- Dependencies: 176
+-- Data for Name: members; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+
+
+--
+-- TOC entry 2249 (class 0 OID 175653)
+-- Dependencies: 174
+-- Data for Name: system_settings; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (1, 'levels', '3', '提成层数', '2017-06-06 09:50:27.641084');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (2, 'level0ratio', '0.1', '第0层分成比例', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (3, 'level1ratio', '0.05', '第1层分成比例', '2017-06-06 09:51:00');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (4, 'level2ratio', '0.03', '第2层分成比例', '2017-06-06 09:51:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (5, 'level3ratio', '0.02', '第3层分成比例', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (6, 'NewUsereBonus', '500', '单位人民币分', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (7, 'PointValidDays', '365', '积分有效天数,自生效日起,<=0永不过期', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (8, 'TransferEnabled', '0', '会员转赠开关,1开启,0关闭', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (9, 'TransferDailyLimit', '0', '会员每日转出上限,单位人民币分,<=0不限', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (10, 'RebateRounding', 'half_up', '返利舍入方式,half_up四舍五入,bankers银行家舍入,truncate截断', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (11, 'RebateResidual', 'last', '返利舍入差额归属,last最后一级返利,platform平台舍入差额科目', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (12, 'RebateCapLevel', '0', '每单每一级返利上限,单位人民币分,<=0不限', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (13, 'RebateCapDaily', '0', '每位上级每日返利收入上限,单位人民币分,<=0不限', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (14, 'RebateCapMonthly', '0', '每位上级每月返利收入上限,单位人民币分,<=0不限', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (15, 'RebateCapOrder', '0', '每单返利合计上限,单位人民币分,<=0不限', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (16, 'TierWindowDays', '365', '会员等级评定消费统计天数', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (17, 'AvailableDays', '0', '返利到账天数T+n,期间不可抵用提现,退款直接取消;按钱包配置code为AvailableDays.钱包类型', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (18, 'RechargeRebate', '0', '充值返利开关,1按消费相同分成比例为上级返利,0关闭', '2017-06-06 09:52:01');
+INSERT INTO system_settings (id, code, value, remark, updtime) VALUES (19, 'RebindCoolingDays', '0', '解绑后重新绑定推荐人的冷静期天数,0不限制', '2017-06-06 09:52:01');
+
+
+--
+-- Data for Name: ledger_accounts; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+INSERT INTO ledger_accounts (code, name, category) VALUES ('member_wallet', '会员积分钱包', 'liability');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('rebate_expense', '返利费用', 'expense');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('redemption_clearing', '积分抵用清算', 'liability');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('cashout_clearing', '积分提现清算', 'liability');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('expiry_income', '积分过期收入', 'income');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('adjustment', '调整', 'expense');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('transfer_clearing', '会员转赠清算', 'liability');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('cashout_fee_income', '提现手续费收入', 'income');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('rounding_residual', '返利舍入差额', 'income');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('recharge_clearing', '渠道充值清算', 'asset');
+INSERT INTO ledger_accounts (code, name, category) VALUES ('promo_expense', '促销赠送费用', 'expense');
+
+
+--
+-- TOC entry 2267 (class 0 OID 0)
+-- Dependencies: 173
+-- Name: systemsettings_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
+--
+
+SELECT pg_catalog.setval('systemsettings_id_seq', 19, true);
+
+
+--
+-- TOC entry 2250 (class 0 OID 175660)
+-- Dependencies: 175
+-- Data for Name: transactions; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+
+
+--
+-- TOC entry 2253 (class 0 OID 175671)
+-- Dependencies: 178
+-- Data for Name: user_levels; Type: TABLE DATA; Schema: public; Owner: -
+--
+
+
+
+--
+-- TOC entry 2268 (class 0 OID 0)
+-- Dependencies: 177
+-- Name: user_levels_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
+--
+
+SELECT pg_catalog.setval('user_levels_id_seq', 1, false);
+
+
+--
+-- TOC entry 2125 (class 2606 OID 175713)
+-- Name: accounts_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY accounts
+    ADD CONSTRAINT accounts_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: account_usages_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY account_usages
+    ADD CONSTRAINT account_usages_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: adjustments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY adjustments
+    ADD CONSTRAINT adjustments_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: adjustments_transaction_id_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY adjustments
+    ADD CONSTRAINT adjustments_transaction_id_key UNIQUE (transaction_id);
+
+
+--
+-- Name: cashout_requests_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY cashout_requests
+    ADD CONSTRAINT cashout_requests_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: cashout_logs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY cashout_logs
+    ADD CONSTRAINT cashout_logs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: cashout_requests_status_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX cashout_requests_status_idx ON cashout_requests USING btree (status, createtime);
+
+
+--
+-- Name: cashout_logs_request_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX cashout_logs_request_id_idx ON cashout_logs USING btree (request_id);
+
+
+--
+-- Name: cashout_policies_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY cashout_policies
+    ADD CONSTRAINT cashout_policies_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: cashout_policies_level_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX cashout_policies_level_idx ON cashout_policies USING btree ((COALESCE(level, ''::text)));
+
+
+--
+-- Name: rebate_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY rebate_rules
+    ADD CONSTRAINT rebate_rules_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: rebate_rules_merchant_category_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX rebate_rules_merchant_category_idx ON rebate_rules USING btree ((COALESCE(merchant, ''::text)), (COALESCE(category, ''::text)));
+
+
+--
+-- Name: tier_ratios_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY tier_ratios
+    ADD CONSTRAINT tier_ratios_pkey PRIMARY KEY (level, generation);
+
+
+--
+-- Name: tier_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY tier_rules
+    ADD CONSTRAINT tier_rules_pkey PRIMARY KEY (level);
+
+
+--
+-- Name: tier_changes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY tier_changes
+    ADD CONSTRAINT tier_changes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: tier_changes_member_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX tier_changes_member_id_idx ON tier_changes USING btree (member_id, id);
+
+
+--
+-- Name: campaigns_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY campaigns
+    ADD CONSTRAINT campaigns_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: campaigns_time_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX campaigns_time_idx ON campaigns USING btree (starttime, endtime);
+
+
+--
+-- Name: recharges_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY recharges
+    ADD CONSTRAINT recharges_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: recharges_channel_externalref_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY recharges
+    ADD CONSTRAINT recharges_channel_externalref_key UNIQUE (channel, externalref);
+
+
+--
+-- Name: recharge_tiers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY recharge_tiers
+    ADD CONSTRAINT recharge_tiers_pkey PRIMARY KEY (minamount);
+
+
+--
+-- Name: reference_changes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY reference_changes
+    ADD CONSTRAINT reference_changes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: reference_changes_member_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX reference_changes_member_id_idx ON reference_changes USING btree (member_id, id);
+
+
+--
+-- Name: idempotency_keys_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY idempotency_keys
+    ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (member_id, idemkey);
+
+
+--
+-- Name: ledger_accounts_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY ledger_accounts
+    ADD CONSTRAINT ledger_accounts_pkey PRIMARY KEY (code);
+
+
+--
+-- Name: journal_entries_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY journal_entries
+    ADD CONSTRAINT journal_entries_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: postings_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY postings
+    ADD CONSTRAINT postings_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: postings_account_member_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX postings_account_member_id_idx ON postings USING btree (account, member_id);
+
+
+--
+-- Name: orders_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY orders
+    ADD CONSTRAINT orders_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: orders_member_id_orderno_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX orders_member_id_orderno_idx ON orders USING btree (member_id, orderno);
+
+
+--
+-- Name: transactions_reverse_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX transactions_reverse_id_idx ON transactions USING btree (reverse_id);
+
+
+--
+-- Name: accounts_transaction_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX accounts_transaction_id_idx ON accounts USING btree (transaction_id);
+
+
+--
+-- Name: account_usages_transaction_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX account_usages_transaction_id_idx ON account_usages USING btree (transaction_id);
+
+
+--
+-- TOC entry 2131 (class 2606 OID 175677)
+-- Name: members_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY members
+    ADD CONSTRAINT members_pkey PRIMARY KEY (id);
+
+
+--
+-- TOC entry 2127 (class 2606 OID 175715)
+-- Name: system_settings_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY system_settings
+    ADD CONSTRAINT system_settings_pkey PRIMARY KEY (id);
+
+
+--
+-- TOC entry 2129 (class 2606 OID 175709)
+-- Name: transactions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY transactions
+    ADD CONSTRAINT transactions_pkey PRIMARY KEY (id);
+
+
+--
+-- TOC entry 2133 (class 2606 OID 175711)
+-- Name: user_levels_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY user_levels
+    ADD CONSTRAINT user_levels_pkey PRIMARY KEY (id);
+
+
+--
+-- TOC entry 2134 (class 2606 OID 175693)
+-- Name: accounts_member_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY accounts
+    ADD CONSTRAINT accounts_member_id_fkey FOREIGN KEY (member_id) REFERENCES members(id);
+
+
+--
+-- Name: adjustments_transaction_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY adjustments
+    ADD CONSTRAINT adjustments_transaction_id_fkey FOREIGN KEY (transaction_id) REFERENCES transactions(id);
+
+
+--
+-- Name: cashout_requests_transaction_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY cashout_requests
+    ADD CONSTRAINT cashout_requests_transaction_id_fkey FOREIGN KEY (transaction_id) REFERENCES transactions(id);
+
+
+--
+-- Name: cashout_logs_request_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY cashout_logs
+    ADD CONSTRAINT cashout_logs_request_id_fkey FOREIGN KEY (request_id) REFERENCES cashout_requests(id);
+
+
+--
+-- Name: tier_changes_member_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY tier_changes
+    ADD CONSTRAINT tier_changes_member_id_fkey FOREIGN KEY (member_id) REFERENCES members(id);
+
+
+--
+-- Name: transactions_account_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY transactions
+    ADD CONSTRAINT transactions_account_id_fkey FOREIGN KEY (account_id) REFERENCES accounts(id);
+
+
+--
+-- Name: transactions_campaign_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY transactions
+    ADD CONSTRAINT transactions_campaign_id_fkey FOREIGN KEY (campaign_id) REFERENCES campaigns(id);
+
+
+--
+-- Name: recharges_member_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY recharges
+    ADD CONSTRAINT recharges_member_id_fkey FOREIGN KEY (member_id) REFERENCES members(id);
+
+
+--
+-- Name: recharges_transaction_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY recharges
+    ADD CONSTRAINT recharges_transaction_id_fkey FOREIGN KEY (transaction_id) REFERENCES transactions(id);
+
+
+--
+-- Name: reference_changes_member_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY reference_changes
+    ADD CONSTRAINT reference_changes_member_id_fkey FOREIGN KEY (member_id) REFERENCES members(id);
+
+
+--
+-- Name: reference_changes_oldreference_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY reference_changes
+    ADD CONSTRAINT reference_changes_oldreference_id_fkey FOREIGN KEY (oldreference_id) REFERENCES members(id);
+
+
+--
+-- Name: reference_changes_newreference_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY reference_changes
+    ADD CONSTRAINT reference_changes_newreference_id_fkey FOREIGN KEY (newreference_id) REFERENCES members(id);
+
+
+--
+-- Name: postings_entry_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY postings
+    ADD CONSTRAINT postings_entry_id_fkey FOREIGN KEY (entry_id) REFERENCES journal_entries(id);
+
+
+--
+-- Name: postings_account_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY postings
+    ADD CONSTRAINT postings_account_fkey FOREIGN KEY (account) REFERENCES ledger_accounts(code);
+
+
+--
+-- Name: account_usages_account_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY account_usages
+    ADD CONSTRAINT account_usages_account_id_fkey FOREIGN KEY (account_id) REFERENCES accounts(id);
 
 
 --